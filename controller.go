@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/net/metrics/push"
+)
+
+// ErrPushAlreadyRunning is returned by Controller.Push when a previous
+// push goroutine is still running; stop it before starting another.
+var ErrPushAlreadyRunning = errors.New("metrics: a push goroutine is already running")
+
+// Controller exposes a Registry's metrics for both pull-based scraping
+// (it's an http.Handler) and push-based delivery (via Push). Obtain one
+// from New, alongside the Registry it controls.
+type Controller struct {
+	core *registryCore
+
+	pushing int32 // atomic; guards against concurrent Push goroutines
+}
+
+// Push starts a goroutine that snapshots the registry and delivers it to
+// target every interval, until the returned stop function is called. It
+// returns ErrPushAlreadyRunning if a previous push goroutine is still
+// active.
+func (c *Controller) Push(target push.Target, interval time.Duration) (stop func(), err error) {
+	if !atomic.CompareAndSwapInt32(&c.pushing, 0, 1) {
+		return nil, ErrPushAlreadyRunning
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = target.Push(c.snapshot())
+			}
+		}
+	}()
+
+	var stopOnce int32
+	return func() {
+		if !atomic.CompareAndSwapInt32(&stopOnce, 0, 1) {
+			return
+		}
+		close(done)
+		<-stopped
+		atomic.StoreInt32(&c.pushing, 0)
+	}, nil
+}
+
+// ServeHTTP renders every registered metric, making Controller suitable
+// for direct use as a handler behind a "/metrics" route. It defaults to
+// the Prometheus text exposition format, but switches to OpenMetrics -
+// including exemplars on histogram buckets - when the request's Accept
+// header asks for it.
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if negotiateFormat(r) {
+		w.Header().Set("Content-Type", openMetricsResponseContentType())
+		_ = renderOpenMetrics(w, c.core)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = renderPrometheus(w, c.core)
+}
+
+// snapshot gathers every push-enabled scalar and vector child in the
+// registry into a push.Snapshot.
+func (c *Controller) snapshot() push.Snapshot {
+	c.core.mu.Lock()
+	families := make([]*family, 0, len(c.core.families))
+	for _, fam := range c.core.families {
+		families = append(families, fam)
+	}
+	c.core.mu.Unlock()
+
+	var snap push.Snapshot
+	for _, fam := range families {
+		for _, s := range fam.scalars {
+			if s.disablePush {
+				continue
+			}
+			appendMetric(&snap, fam.name, fam.kind, s.labels, s.metric)
+		}
+		for _, v := range fam.vectors {
+			if v.disablePush {
+				continue
+			}
+			for _, child := range vectorChildren(v) {
+				appendMetric(&snap, fam.name, fam.kind, child.labels, child.metric)
+			}
+		}
+	}
+	return snap
+}
+
+func appendMetric(snap *push.Snapshot, name string, kind metricKind, labels Labels, metric interface{}) {
+	switch kind {
+	case kindCounter:
+		c := metric.(*Counter)
+		snap.Counters = append(snap.Counters, push.Counter{Name: name, Labels: labels, Value: c.Load()})
+	case kindGauge:
+		g := metric.(*Gauge)
+		snap.Gauges = append(snap.Gauges, push.Gauge{Name: name, Labels: labels, Value: g.Load()})
+	case kindHistogram:
+		h := metric.(*Histogram)
+		buckets := make(map[float64]int64, len(h.buckets)+1)
+		cumulative := h.cumulative()
+		for _, upperBound := range h.buckets {
+			buckets[float64(upperBound)] = cumulative[float64(upperBound)]
+		}
+		buckets[math.MaxFloat64] = cumulative[math.MaxFloat64]
+		snap.Histograms = append(snap.Histograms, push.Histogram{
+			Name:    name,
+			Labels:  labels,
+			Unit:    h.unit,
+			Buckets: buckets,
+		})
+	case kindSummary:
+		s := metric.(*Summary)
+		sum, count, quantiles := s.snapshot()
+		snap.Summaries = append(snap.Summaries, push.Summary{
+			Name:      name,
+			Labels:    labels,
+			Sum:       sum,
+			Count:     int64(count),
+			Quantiles: quantiles,
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package grpcmetrics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"go.uber.org/net/metrics"
+)
+
+// clientSuite is the suite of metrics shared by a Registry's unary and
+// streaming client interceptors, namespaced under "grpc_client" so it
+// never collides with the server suite's "grpc_server" metrics in the
+// same Registry.
+type clientSuite struct {
+	*suite
+}
+
+func newClientSuite(r *metrics.Registry, opts options) (*clientSuite, error) {
+	s, err := newSuite(r, "grpc_client", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &clientSuite{s}, nil
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that records
+// grpc_client_started_total, grpc_client_handled_total, and
+// grpc_client_handling_milliseconds for every unary RPC made through the
+// resulting client connection.
+func UnaryClientInterceptor(r *metrics.Registry, opts ...Option) (grpc.UnaryClientInterceptor, error) {
+	s, err := newClientSuite(r, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(
+		ctx context.Context,
+		fullMethod string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		service, method := splitMethodName(fullMethod)
+		s.recordStart(service, method)
+
+		start := time.Now()
+		err := invoker(ctx, fullMethod, req, reply, cc, callOpts...)
+		s.recordHandled(service, method, status.Code(err).String(), start)
+
+		return err
+	}, nil
+}
+
+// StreamClientInterceptor returns a grpc.StreamClientInterceptor that
+// records the same RPC-level metrics as UnaryClientInterceptor, plus
+// grpc_client_msg_received_total and grpc_client_msg_sent_total for every
+// message sent or received over the stream.
+func StreamClientInterceptor(r *metrics.Registry, opts ...Option) (grpc.StreamClientInterceptor, error) {
+	s, err := newClientSuite(r, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		fullMethod string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		service, method := splitMethodName(fullMethod)
+		s.recordStart(service, method)
+
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, fullMethod, callOpts...)
+		if err != nil {
+			s.recordHandled(service, method, status.Code(err).String(), start)
+			return nil, err
+		}
+
+		return &monitoredClientStream{
+			ClientStream: cs,
+			suite:        s.suite,
+			service:      service,
+			method:       method,
+			onFinish: func(err error) {
+				s.recordHandled(service, method, status.Code(err).String(), start)
+			},
+		}, nil
+	}, nil
+}
+
+// monitoredClientStream wraps a grpc.ClientStream so that every SendMsg and
+// RecvMsg call updates the msg_sent/msg_received counters, and so that
+// completion of the stream (the first non-nil error, usually io.EOF) is
+// recorded against grpc_client_handled_total/grpc_client_handling_milliseconds.
+type monitoredClientStream struct {
+	grpc.ClientStream
+	suite           *suite
+	service, method string
+	finishOnce      sync.Once
+	onFinish        func(error)
+}
+
+func (s *monitoredClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.suite.recordSent(s.service, s.method)
+	}
+	return err
+}
+
+func (s *monitoredClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.suite.recordReceived(s.service, s.method)
+		return nil
+	}
+	// The stream is done (successfully, via io.EOF, or with an error);
+	// either way, record it exactly once. io.EOF is grpc-go's sentinel
+	// for a clean stream close, not a gRPC status error, so report it as
+	// codes.OK rather than letting status.Code fall back to Unknown.
+	finishErr := err
+	if err == io.EOF {
+		finishErr = nil
+	}
+	s.finishOnce.Do(func() { s.onFinish(finishErr) })
+	return err
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package grpcmetrics provides gRPC server and client interceptors that
+// record the standard RED-style metrics (rate, errors, duration) using a
+// *metrics.Registry. It mirrors what grpc-ecosystem/go-grpc-prometheus
+// offers, but binds to this module's registry semantics - including its
+// label scrubbing and duplicate-detection rules - instead of registering
+// directly with a global Prometheus default registry.
+package grpcmetrics
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/net/metrics"
+)
+
+// defaultBuckets are the handling-time histogram buckets used when no
+// custom buckets are supplied, in milliseconds: a typical RPC latency
+// spread from 5ms to 10s.
+var defaultBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// suite bundles the metrics recorded by one direction's (server or client)
+// interceptor. Every interceptor constructor registers a new suite, so a
+// second server (or client) interceptor of either kind, unary or streaming,
+// built from the same Registry fails with a duplicate-registration error;
+// suites aren't shared between interceptors.
+type suite struct {
+	started     *metrics.CounterVector   // {grpc_service, grpc_method}
+	handled     *metrics.CounterVector   // {grpc_service, grpc_method, grpc_code}
+	handling    *metrics.HistogramVector // {grpc_service, grpc_method}
+	msgReceived *metrics.CounterVector   // {grpc_service, grpc_method}
+	msgSent     *metrics.CounterVector   // {grpc_service, grpc_method}
+}
+
+// options configures interceptor construction. Use the With* functions
+// below to set them.
+type options struct {
+	buckets []int64
+}
+
+// Option configures the interceptors returned by the constructors in this
+// package.
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) { f(o) }
+
+// WithHistogramBuckets overrides the default handling-time histogram
+// buckets. Buckets are in milliseconds, matching the rest of this
+// package's duration metrics.
+func WithHistogramBuckets(buckets []int64) Option {
+	return optionFunc(func(o *options) {
+		o.buckets = buckets
+	})
+}
+
+func newOptions(opts []Option) options {
+	o := options{buckets: defaultBuckets}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return o
+}
+
+func newSuite(r *metrics.Registry, namePrefix string, opts options) (*suite, error) {
+	started, err := r.NewCounterVector(metrics.Opts{
+		Name:           namePrefix + "_started_total",
+		Help:           "Total number of RPCs started.",
+		VariableLabels: []string{"grpc_service", "grpc_method"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	handled, err := r.NewCounterVector(metrics.Opts{
+		Name:           namePrefix + "_handled_total",
+		Help:           "Total number of RPCs completed, regardless of success or failure.",
+		VariableLabels: []string{"grpc_service", "grpc_method", "grpc_code"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	handling, err := r.NewHistogramVector(metrics.HistogramOpts{
+		Opts: metrics.Opts{
+			Name:           namePrefix + "_handling_milliseconds",
+			Help:           "Time spent handling an RPC, in milliseconds.",
+			VariableLabels: []string{"grpc_service", "grpc_method"},
+		},
+		Unit:    time.Millisecond,
+		Buckets: opts.buckets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgReceived, err := r.NewCounterVector(metrics.Opts{
+		Name:           namePrefix + "_msg_received_total",
+		Help:           "Total number of stream messages received.",
+		VariableLabels: []string{"grpc_service", "grpc_method"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgSent, err := r.NewCounterVector(metrics.Opts{
+		Name:           namePrefix + "_msg_sent_total",
+		Help:           "Total number of stream messages sent.",
+		VariableLabels: []string{"grpc_service", "grpc_method"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &suite{
+		started:     started,
+		handled:     handled,
+		handling:    handling,
+		msgReceived: msgReceived,
+		msgSent:     msgSent,
+	}, nil
+}
+
+func (s *suite) recordStart(service, method string) {
+	s.started.MustGet("grpc_service", service, "grpc_method", method).Inc()
+}
+
+func (s *suite) recordHandled(service, method, code string, since time.Time) {
+	s.handled.MustGet("grpc_service", service, "grpc_method", method, "grpc_code", code).Inc()
+	s.handling.MustGet("grpc_service", service, "grpc_method", method).Observe(time.Since(since))
+}
+
+func (s *suite) recordReceived(service, method string) {
+	s.msgReceived.MustGet("grpc_service", service, "grpc_method", method).Inc()
+}
+
+func (s *suite) recordSent(service, method string) {
+	s.msgSent.MustGet("grpc_service", service, "grpc_method", method).Inc()
+}
+
+// splitMethodName splits a gRPC full method name ("/package.Service/Method")
+// into its service and method parts. Malformed names (which shouldn't occur
+// in practice, since grpc-go constructs them) are reported as "unknown".
+func splitMethodName(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.IndexByte(fullMethod, '/'); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
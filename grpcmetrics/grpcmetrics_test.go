@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package grpcmetrics
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.uber.org/net/metrics"
+)
+
+func TestSplitMethodName(t *testing.T) {
+	tests := []struct {
+		full            string
+		service, method string
+	}{
+		{"/users.UserService/Get", "users.UserService", "Get"},
+		{"users.UserService/Get", "users.UserService", "Get"},
+		{"malformed", "unknown", "unknown"},
+		{"", "unknown", "unknown"},
+	}
+
+	for _, tt := range tests {
+		service, method := splitMethodName(tt.full)
+		assert.Equal(t, tt.service, service, "Wrong service for %q.", tt.full)
+		assert.Equal(t, tt.method, method, "Wrong method for %q.", tt.full)
+	}
+}
+
+func TestNewOptionsDefaults(t *testing.T) {
+	o := newOptions(nil)
+	assert.Equal(t, defaultBuckets, o.buckets, "Should use default buckets when none supplied.")
+
+	custom := []int64{1, 2, 3}
+	o = newOptions([]Option{WithHistogramBuckets(custom)})
+	assert.Equal(t, custom, o.buckets, "WithHistogramBuckets should override the defaults.")
+}
+
+func TestUnaryServerInterceptorRejectsDuplicateRegistration(t *testing.T) {
+	root, _ := metrics.New()
+
+	_, err := UnaryServerInterceptor(root)
+	assert.NoError(t, err, "First registration should succeed.")
+
+	_, err = UnaryServerInterceptor(root)
+	assert.Error(t, err, "Second registration on the same Registry should collide on metric names.")
+}
+
+func TestServerAndClientSuitesCoexist(t *testing.T) {
+	root, _ := metrics.New()
+
+	_, err := UnaryServerInterceptor(root)
+	assert.NoError(t, err, "Server interceptor should register successfully.")
+
+	_, err = UnaryClientInterceptor(root)
+	assert.NoError(t, err, "Client interceptor should register successfully alongside the server's metrics.")
+}
+
+// fakeClientStream is a grpc.ClientStream whose RecvMsg always returns a
+// fixed error, letting tests drive monitoredClientStream without a real
+// connection.
+type fakeClientStream struct {
+	grpc.ClientStream
+	recvErr error
+}
+
+func (s *fakeClientStream) RecvMsg(m interface{}) error { return s.recvErr }
+
+func TestMonitoredClientStreamRecvMsgTreatsEOFAsOK(t *testing.T) {
+	var reported codes.Code
+	stream := &monitoredClientStream{
+		ClientStream: &fakeClientStream{recvErr: io.EOF},
+		suite:        &suite{},
+		onFinish:     func(err error) { reported = status.Code(err) },
+	}
+
+	err := stream.RecvMsg(new(interface{}))
+	require.Equal(t, io.EOF, err, "RecvMsg should still return io.EOF to the caller.")
+	assert.Equal(t, codes.OK, reported, "A clean stream close (io.EOF) should be reported as codes.OK, not Unknown.")
+}
+
+func TestMonitoredClientStreamRecvMsgReportsRealErrors(t *testing.T) {
+	var reported codes.Code
+	boom := errors.New("boom")
+	stream := &monitoredClientStream{
+		ClientStream: &fakeClientStream{recvErr: boom},
+		suite:        &suite{},
+		onFinish:     func(err error) { reported = status.Code(err) },
+	}
+
+	err := stream.RecvMsg(new(interface{}))
+	require.Equal(t, boom, err, "RecvMsg should still return the original error to the caller.")
+	assert.Equal(t, codes.Unknown, reported, "A non-status error should still report Unknown.")
+}
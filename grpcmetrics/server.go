@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package grpcmetrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"go.uber.org/net/metrics"
+)
+
+// serverSuite is the suite of metrics shared by a Registry's unary and
+// streaming server interceptors.
+type serverSuite struct {
+	*suite
+}
+
+func newServerSuite(r *metrics.Registry, opts options) (*serverSuite, error) {
+	s, err := newSuite(r, "grpc_server", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &serverSuite{s}, nil
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that records
+// grpc_server_started_total, grpc_server_handled_total, and
+// grpc_server_handling_milliseconds for every unary RPC. Register it once per
+// Registry (multiple calls register duplicate metrics and return an
+// error), and pass the result to grpc.NewServer via grpc.UnaryInterceptor
+// or grpc_middleware.ChainUnaryServer.
+func UnaryServerInterceptor(r *metrics.Registry, opts ...Option) (grpc.UnaryServerInterceptor, error) {
+	s, err := newServerSuite(r, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		service, method := splitMethodName(info.FullMethod)
+		s.recordStart(service, method)
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		s.recordHandled(service, method, status.Code(err).String(), start)
+
+		return resp, err
+	}, nil
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that
+// records the same RPC-level metrics as UnaryServerInterceptor, plus
+// grpc_server_msg_received_total and grpc_server_msg_sent_total for every
+// message sent or received over the stream.
+func StreamServerInterceptor(r *metrics.Registry, opts ...Option) (grpc.StreamServerInterceptor, error) {
+	s, err := newServerSuite(r, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		service, method := splitMethodName(info.FullMethod)
+		s.recordStart(service, method)
+
+		start := time.Now()
+		err := handler(srv, &monitoredServerStream{
+			ServerStream: ss,
+			suite:        s.suite,
+			service:      service,
+			method:       method,
+		})
+		s.recordHandled(service, method, status.Code(err).String(), start)
+
+		return err
+	}, nil
+}
+
+// monitoredServerStream wraps a grpc.ServerStream so that every SendMsg and
+// RecvMsg call updates the msg_sent/msg_received counters.
+type monitoredServerStream struct {
+	grpc.ServerStream
+	suite           *suite
+	service, method string
+}
+
+func (s *monitoredServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.suite.recordSent(s.service, s.method)
+	}
+	return err
+}
+
+func (s *monitoredServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.suite.recordReceived(s.service, s.method)
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HistogramOpts configures a Histogram or HistogramVector.
+type HistogramOpts struct {
+	Opts
+
+	// Unit is the duration that each value in Buckets is a multiple of,
+	// e.g. time.Millisecond if Buckets are bucket boundaries expressed in
+	// milliseconds.
+	Unit time.Duration
+	// Buckets are the histogram's bucket upper bounds, expressed as
+	// multiples of Unit. They don't need to be pre-sorted.
+	Buckets []int64
+}
+
+func (o HistogramOpts) withDefaults() HistogramOpts {
+	cp := o
+	if cp.Unit <= 0 {
+		cp.Unit = time.Millisecond
+	}
+	sorted := append([]int64(nil), cp.Buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	cp.Buckets = sorted
+	return cp
+}
+
+// Histogram tracks the distribution of a stream of values (typically
+// durations) across a fixed set of buckets. Use Registry.NewHistogram to
+// construct one.
+type Histogram struct {
+	unit    time.Duration
+	buckets []int64 // sorted ascending
+
+	// counts[i] is the number of observations that fell into buckets[i]
+	// specifically (not cumulatively); counts[len(buckets)] is the
+	// overflow ("+Inf") bucket.
+	counts []int64
+
+	// exemplars holds the most recent exemplar recorded for each bucket
+	// (keyed by upper bound, +Inf keyed by math.MaxFloat64), lazily
+	// allocated by ObserveWithExemplar. Keeping this on the Histogram
+	// itself - rather than in a package-level table - means its exemplars
+	// are freed along with it.
+	exemplarsMu sync.Mutex
+	exemplars   map[float64]Exemplar
+}
+
+func newHistogram(opts HistogramOpts) *Histogram {
+	opts = opts.withDefaults()
+	return &Histogram{
+		unit:    opts.Unit,
+		buckets: opts.Buckets,
+		counts:  make([]int64, len(opts.Buckets)+1),
+	}
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(value time.Duration) {
+	atomic.AddInt64(&h.counts[h.bucketIndex(value)], 1)
+}
+
+// bucketIndex returns the index (into h.buckets, or len(h.buckets) for the
+// overflow bucket) of the smallest bucket that the observation falls into.
+func (h *Histogram) bucketIndex(value time.Duration) int {
+	scaled := float64(value) / float64(h.unit)
+	for i, upperBound := range h.buckets {
+		if scaled <= float64(upperBound) {
+			return i
+		}
+	}
+	return len(h.buckets)
+}
+
+// values returns the histogram's own (non-cumulative) per-bucket counts,
+// keyed by bucket upper bound; the overflow bucket is keyed by
+// math.MaxFloat64, matching the +Inf sentinel used elsewhere in this
+// package's histogram snapshots.
+func (h *Histogram) values() map[float64]int64 {
+	out := make(map[float64]int64, len(h.buckets)+1)
+	for i, upperBound := range h.buckets {
+		out[float64(upperBound)] = atomic.LoadInt64(&h.counts[i])
+	}
+	out[math.MaxFloat64] = atomic.LoadInt64(&h.counts[len(h.buckets)])
+	return out
+}
+
+// cumulative returns the histogram's per-bucket counts accumulated from
+// the smallest bucket up, as required by the Prometheus/OpenMetrics
+// exposition formats and by the remote_write/StatsD push backends.
+func (h *Histogram) cumulative() map[float64]int64 {
+	out := make(map[float64]int64, len(h.buckets)+1)
+	var running int64
+	for i, upperBound := range h.buckets {
+		running += atomic.LoadInt64(&h.counts[i])
+		out[float64(upperBound)] = running
+	}
+	running += atomic.LoadInt64(&h.counts[len(h.buckets)])
+	out[math.MaxFloat64] = running
+	return out
+}
+
+func (h *Histogram) sum() float64 {
+	var sum float64
+	for i, upperBound := range h.buckets {
+		sum += float64(upperBound) * float64(atomic.LoadInt64(&h.counts[i]))
+	}
+	// Overflow observations don't have a representable value; approximate
+	// with the largest finite bucket bound rather than +Inf.
+	if len(h.buckets) > 0 {
+		sum += float64(h.buckets[len(h.buckets)-1]) * float64(atomic.LoadInt64(&h.counts[len(h.buckets)]))
+	}
+	return sum
+}
+
+func (h *Histogram) count() int64 {
+	var total int64
+	for i := range h.counts {
+		total += atomic.LoadInt64(&h.counts[i])
+	}
+	return total
+}
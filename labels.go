@@ -0,0 +1,162 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+)
+
+// scrub replaces every byte that isn't valid in a Prometheus metric or
+// label name with an underscore, matching the official client's
+// sanitization rules.
+func scrub(s string) string {
+	out := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+			out[i] = c
+		default:
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
+
+// scrubLabels scrubs every key in labels, returning an error if two
+// distinct keys scrub to the same name - that's ambiguous, since we can no
+// longer tell which original key's value should win.
+func scrubLabels(labels Labels) (Labels, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	out := make(Labels, len(labels))
+	for k, v := range labels {
+		sk := scrub(k)
+		if _, ok := out[sk]; ok {
+			return nil, fmt.Errorf("metrics: label name %q collides with another label after scrubbing", k)
+		}
+		out[sk] = v
+	}
+	return out, nil
+}
+
+// scrubVariableLabels scrubs every name in names, returning an error if two
+// distinct names scrub to the same name.
+func scrubVariableLabels(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	out := make([]string, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for i, n := range names {
+		sn := scrub(n)
+		if _, ok := seen[sn]; ok {
+			return nil, fmt.Errorf("metrics: variable label name %q collides with another variable label after scrubbing", n)
+		}
+		seen[sn] = struct{}{}
+		out[i] = sn
+	}
+	return out, nil
+}
+
+// mergeLabels combines a registry's scope labels with a metric's own
+// constant labels. The metric's own labels win on key collisions.
+func mergeLabels(scope, own Labels) Labels {
+	if len(scope) == 0 {
+		return own
+	}
+	out := make(Labels, len(scope)+len(own))
+	for k, v := range scope {
+		out[k] = v
+	}
+	for k, v := range own {
+		out[k] = v
+	}
+	return out
+}
+
+// sortedKeys returns labels' keys in sorted order, for deterministic
+// iteration (Prometheus exposition, Tally snapshot keys, and so on).
+func sortedKeys(labels Labels) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dimensionSet returns the set of dimension names (both constant label
+// keys and variable label names) that a metric's identity is keyed on.
+func dimensionSet(labels Labels, variableLabels []string) map[string]struct{} {
+	dims := make(map[string]struct{}, len(labels)+len(variableLabels))
+	for k := range labels {
+		dims[k] = struct{}{}
+	}
+	for _, n := range variableLabels {
+		dims[n] = struct{}{}
+	}
+	return dims
+}
+
+func dimensionSetsEqual(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValueKey renders a constant label set as a sorted "k=v,k=v" string,
+// used to detect two registrations for the same family with identical
+// label values.
+func labelValueKey(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := sortedKeys(labels)
+	var out []byte
+	for i, k := range keys {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, k...)
+		out = append(out, '=')
+		out = append(out, labels[k]...)
+	}
+	return string(out)
+}
+
+// tallyKey renders a metric's name and labels into the "name+k=v,k=v" key
+// format that Tally's test scope uses for its snapshot maps.
+func tallyKey(name string, labels Labels) string {
+	suffix := labelValueKey(labels)
+	if suffix == "" {
+		return name
+	}
+	return name + "+" + suffix
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	_openMetricsContentType = "application/openmetrics-text"
+	_openMetricsVersion     = "1.0.0"
+
+	// _maxExemplarLabelBytes is the OpenMetrics-mandated cap on the combined
+	// size of an exemplar's label set.
+	_maxExemplarLabelBytes = 128
+)
+
+// negotiateFormat inspects the Accept header and reports whether the
+// client has asked for OpenMetrics rather than the default Prometheus text
+// format. It follows the same content-negotiation rules as the official
+// OpenMetrics exposition: the client must explicitly request the
+// "application/openmetrics-text" media type, optionally pinning a version.
+func negotiateFormat(r *http.Request) (openMetrics bool) {
+	for _, accept := range r.Header[http.CanonicalHeaderKey("Accept")] {
+		for _, part := range splitAccept(accept) {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			if mediaType != _openMetricsContentType {
+				continue
+			}
+			if v, ok := params["version"]; ok && v != _openMetricsVersion {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func splitAccept(header string) []string {
+	var parts []string
+	start := 0
+	for i, r := range header {
+		if r == ',' {
+			parts = append(parts, header[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, header[start:])
+	return parts
+}
+
+// writeOpenMetricsFooter terminates an OpenMetrics exposition, as required
+// by the spec: every response must end with "# EOF".
+func writeOpenMetricsFooter(w io.Writer) error {
+	_, err := io.WriteString(w, "# EOF\n")
+	return err
+}
+
+// openMetricsResponseContentType is the Content-Type header value to send
+// alongside an OpenMetrics body.
+func openMetricsResponseContentType() string {
+	return fmt.Sprintf("%s; version=%s; charset=utf-8", _openMetricsContentType, _openMetricsVersion)
+}
+
+// Exemplar attaches trace context to a single histogram bucket observation,
+// letting users correlate a latency bucket with the specific trace that
+// landed in it. Exemplars are best-effort: only the most recent observation
+// per bucket is retained, and oversized label sets are dropped rather than
+// truncated.
+type Exemplar struct {
+	Labels    Labels
+	Value     float64
+	Timestamp time.Time
+}
+
+// ObserveWithExemplar behaves like Observe, but additionally attaches the
+// supplied labels (e.g. {"trace_id": "...", "span_id": "..."}) as an
+// OpenMetrics exemplar on whichever bucket the observation falls into.
+// Label sets larger than 128 UTF-8 bytes are dropped rather than truncated,
+// per the OpenMetrics spec; the observation itself is always recorded
+// either way. Exemplars only appear in the OpenMetrics exposition, not the
+// default Prometheus text output.
+func (h *Histogram) ObserveWithExemplar(value time.Duration, labels Labels) {
+	h.Observe(value)
+
+	if labelsByteSize(labels) > _maxExemplarLabelBytes {
+		return
+	}
+
+	le := h.bucketUpperBound(value)
+	scaled := float64(value) / float64(h.unit)
+	h.exemplarsMu.Lock()
+	if h.exemplars == nil {
+		h.exemplars = make(map[float64]Exemplar)
+	}
+	h.exemplars[le] = Exemplar{Labels: labels, Value: scaled, Timestamp: time.Now()}
+	h.exemplarsMu.Unlock()
+}
+
+// bucketUpperBound returns the upper bound (in the histogram's configured
+// Unit) of the smallest bucket that the observation falls into, mirroring
+// the bucketing the Prometheus exporter already applies when rendering
+// "_bucket" lines. Observations beyond the largest configured bucket fall
+// into the implicit "+Inf" bucket.
+func (h *Histogram) bucketUpperBound(value time.Duration) float64 {
+	scaled := float64(value) / float64(h.unit)
+	for _, upperBound := range h.buckets {
+		if scaled <= float64(upperBound) {
+			return float64(upperBound)
+		}
+	}
+	return math.MaxFloat64
+}
+
+// exemplarFor returns the exemplar recorded for the given bucket, if any.
+func (h *Histogram) exemplarFor(le float64) (Exemplar, bool) {
+	h.exemplarsMu.Lock()
+	defer h.exemplarsMu.Unlock()
+	e, ok := h.exemplars[le]
+	return e, ok
+}
+
+func labelsByteSize(labels Labels) int {
+	n := 0
+	for k, v := range labels {
+		// "key=\"value\"," - approximate the rendered size, including
+		// quoting and the separating comma.
+		n += len(k) + len(v) + 4
+	}
+	return n
+}
+
+// writeExemplar renders a single exemplar as an OpenMetrics `# {...} value
+// timestamp` trailer, appended to a `_bucket` line.
+func writeExemplar(w io.Writer, e Exemplar) error {
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	_, err := io.WriteString(w, " # {")
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s=%q", k, e.Labels[k]); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "} %s %d\n", formatFloat(e.Value), e.Timestamp.UnixNano()/int64(time.Millisecond))
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{"no accept header", "", false},
+		{"plain text", "text/plain", false},
+		{"openmetrics", "application/openmetrics-text", true},
+		{"openmetrics pinned version", "application/openmetrics-text; version=1.0.0", true},
+		{"openmetrics other version", "application/openmetrics-text; version=0.0.4", false},
+		{"openmetrics among others", "text/plain;q=0.5, application/openmetrics-text", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.want, negotiateFormat(r))
+		})
+	}
+}
+
+func TestWriteOpenMetricsFooter(t *testing.T) {
+	var buf strings.Builder
+	require.NoError(t, writeOpenMetricsFooter(&buf))
+	assert.Equal(t, "# EOF\n", buf.String())
+}
+
+func TestLabelsByteSize(t *testing.T) {
+	small := Labels{"trace_id": "abc"}
+	big := Labels{"trace_id": strings.Repeat("a", 200)}
+	assert.Less(t, labelsByteSize(small), _maxExemplarLabelBytes)
+	assert.Greater(t, labelsByteSize(big), _maxExemplarLabelBytes)
+}
+
+func TestWriteExemplar(t *testing.T) {
+	var buf strings.Builder
+	require.NoError(t, writeExemplar(&buf, Exemplar{Labels: Labels{"trace_id": "abc123"}, Value: 42}))
+	assert.Contains(t, buf.String(), `trace_id="abc123"`)
+	assert.Contains(t, buf.String(), "} 42 ", "Exemplar trailer should render the observed value, not a placeholder.")
+	assert.True(t, strings.HasPrefix(buf.String(), " # {"), "Exemplar trailer should start with ` # {`.")
+}
+
+func TestControllerNegotiatesOpenMetrics(t *testing.T) {
+	reg, controller := New()
+	hist, err := reg.NewHistogram(HistogramOpts{
+		Opts:    Opts{Name: "test_histogram", Help: "help"},
+		Unit:    time.Millisecond,
+		Buckets: []int64{1000},
+	})
+	require.NoError(t, err, "Failed to create histogram.")
+	hist.ObserveWithExemplar(500*time.Millisecond, Labels{"trace_id": "abc123"})
+
+	server := httptest.NewServer(controller)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err, "Failed to build request.")
+	req.Header.Set("Accept", "application/openmetrics-text")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Failed to scrape controller.")
+	defer resp.Body.Close()
+
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/openmetrics-text")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "Failed to read response body.")
+	assert.True(t, strings.HasSuffix(string(body), "# EOF\n"), "OpenMetrics body should end with the EOF marker.")
+	assert.Contains(t, string(body), `trace_id="abc123"`, "Bucket line should carry the recorded exemplar.")
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import "time"
+
+// Labels are a metric's constant (for scalars) or variable (for vectors)
+// dimensions, keyed by label name.
+type Labels map[string]string
+
+// Opts configures a single metric or metric vector. Every New* constructor
+// on Registry takes an Opts (or an Opts-embedding struct, like
+// HistogramOpts).
+type Opts struct {
+	// Name is the metric's name, e.g. "requests_total". It's scrubbed of
+	// characters that aren't valid in a Prometheus metric name before
+	// being used to enforce uniqueness.
+	Name string
+	// Help is a human-readable description of the metric. Changing the
+	// help text doesn't change the metric's identity - two registrations
+	// with the same name and dimensions but different help text still
+	// collide.
+	Help string
+	// Labels are constant dimensions: every instance of this metric carries
+	// the same label values. Typically used for labels that vary by
+	// process (e.g. "service") rather than by request.
+	Labels Labels
+	// VariableLabels names the dimensions of a metric vector. Each call to
+	// the vector's MustGet supplies the corresponding values. Leave this
+	// unset to register a scalar metric instead of a vector.
+	VariableLabels []string
+	// DisablePush excludes this metric from Controller.Push, while still
+	// registering it for Prometheus/OpenMetrics scrapes. Useful for
+	// metrics that are cheap to scrape but too high-volume to ship to a
+	// push-based backend on every tick.
+	DisablePush bool
+	// MaxCardinality caps the number of distinct label-value children a
+	// vector may create. Once the cap is reached, MustGet panics rather
+	// than creating an unbounded number of children. Zero means unbounded.
+	// Only CounterVector and GaugeVector honor it; it's ignored for
+	// HistogramVector, SummaryVector, and scalar metrics.
+	MaxCardinality int
+	// IdleTTL evicts a vector's children once they haven't been accessed
+	// for this long, bounding memory use for high-cardinality label sets
+	// that come and go over time (e.g. per-request-path metrics). Zero
+	// disables eviction. Only CounterVector and GaugeVector honor it; it's
+	// ignored for HistogramVector, SummaryVector, and scalar metrics.
+	IdleTTL time.Duration
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// renderPrometheus writes every registered family in the default
+// Prometheus text exposition format: one "# HELP"/"# TYPE" pair per
+// family, followed by one sample line (or bucket/sum/count trio, for
+// histograms) per registered instance.
+func renderPrometheus(w io.Writer, core *registryCore) error {
+	return renderExposition(w, core, false)
+}
+
+// renderOpenMetrics writes every registered family in the OpenMetrics text
+// exposition format: identical to renderPrometheus, except histogram
+// bucket lines carry exemplar trailers where available, and the body is
+// terminated with the "# EOF" marker the spec requires.
+func renderOpenMetrics(w io.Writer, core *registryCore) error {
+	if err := renderExposition(w, core, true); err != nil {
+		return err
+	}
+	return writeOpenMetricsFooter(w)
+}
+
+func renderExposition(w io.Writer, core *registryCore, openMetrics bool) error {
+	core.mu.Lock()
+	families := make([]*family, 0, len(core.families))
+	for _, fam := range core.families {
+		families = append(families, fam)
+	}
+	core.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, fam := range families {
+		if err := renderFamily(w, fam, openMetrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderFamily(w io.Writer, fam *family, openMetrics bool) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n", fam.name, fam.help); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", fam.name, fam.kind); err != nil {
+		return err
+	}
+
+	scalars := append([]*scalarInstance(nil), fam.scalars...)
+	sort.Slice(scalars, func(i, j int) bool {
+		return labelValueKey(scalars[i].labels) < labelValueKey(scalars[j].labels)
+	})
+	for _, s := range scalars {
+		if err := renderMetric(w, fam.name, fam.kind, s.labels, s.metric, openMetrics); err != nil {
+			return err
+		}
+	}
+
+	vectors := append([]*vectorInstance(nil), fam.vectors...)
+	sort.Slice(vectors, func(i, j int) bool {
+		return labelValueKey(vectors[i].labels) < labelValueKey(vectors[j].labels)
+	})
+	for _, v := range vectors {
+		children := vectorChildren(v)
+		sort.Slice(children, func(i, j int) bool {
+			return labelValueKey(children[i].labels) < labelValueKey(children[j].labels)
+		})
+		for _, c := range children {
+			if err := renderMetric(w, fam.name, fam.kind, c.labels, c.metric, openMetrics); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// vectorChild is a single resolved (constant+variable) label set and its
+// underlying metric, pulled out of a vectorInstance for rendering/export.
+type vectorChild struct {
+	labels Labels
+	metric interface{}
+}
+
+func vectorChildren(v *vectorInstance) []vectorChild {
+	var out []vectorChild
+	add := func(values []string, metric interface{}) {
+		labels := make(Labels, len(v.labels)+len(values))
+		for k, val := range v.labels {
+			labels[k] = val
+		}
+		for i, name := range v.variableLabels {
+			labels[name] = values[i]
+		}
+		out = append(out, vectorChild{labels: labels, metric: metric})
+	}
+
+	switch vec := v.vector.(type) {
+	case *CounterVector:
+		vec.each(func(values []string, c *Counter) { add(values, c) })
+	case *GaugeVector:
+		vec.each(func(values []string, g *Gauge) { add(values, g) })
+	case *HistogramVector:
+		vec.each(func(values []string, h *Histogram) { add(values, h) })
+	case *SummaryVector:
+		vec.each(func(values []string, s *Summary) { add(values, s) })
+	}
+	return out
+}
+
+func renderMetric(w io.Writer, name string, kind metricKind, labels Labels, metric interface{}, openMetrics bool) error {
+	switch kind {
+	case kindCounter:
+		c := metric.(*Counter)
+		_, err := fmt.Fprintf(w, "%s%s %d\n", name, promLabelString(labels), c.Load())
+		return err
+	case kindGauge:
+		g := metric.(*Gauge)
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, promLabelString(labels), formatFloat(g.Load()))
+		return err
+	case kindHistogram:
+		return renderHistogram(w, name, labels, metric.(*Histogram), openMetrics)
+	case kindSummary:
+		return metric.(*Summary).writeProm(w, name, labels)
+	default:
+		return nil
+	}
+}
+
+func renderHistogram(w io.Writer, name string, labels Labels, h *Histogram, openMetrics bool) error {
+	bounds := append([]int64(nil), h.buckets...)
+	cumulative := h.cumulative()
+
+	writeBucket := func(bucketLabels Labels, le, count int64) error {
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d", name, promLabelString(bucketLabels), count); err != nil {
+			return err
+		}
+		if openMetrics {
+			if e, ok := h.exemplarFor(float64(le)); ok {
+				return writeExemplar(w, e)
+			}
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+
+	for _, upperBound := range bounds {
+		bucketLabels := withLabel(labels, "le", formatFloat(float64(upperBound)))
+		if err := writeBucket(bucketLabels, upperBound, cumulative[float64(upperBound)]); err != nil {
+			return err
+		}
+	}
+	infLabels := withLabel(labels, "le", "+Inf")
+	total := h.count()
+	if _, err := fmt.Fprintf(w, "%s_bucket%s %d", name, promLabelString(infLabels), total); err != nil {
+		return err
+	}
+	if e, ok := h.exemplarFor(math.MaxFloat64); openMetrics && ok {
+		if err := writeExemplar(w, e); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", name, promLabelString(labels), formatFloat(h.sum())); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count%s %d\n", name, promLabelString(labels), total)
+	return err
+}
+
+func withLabel(labels Labels, key, value string) Labels {
+	out := make(Labels, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
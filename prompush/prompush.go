@@ -0,0 +1,322 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package prompush pushes metrics to a Prometheus-compatible remote_write
+// endpoint. It's a sibling of tallypush: both implement push.Target, so
+// they can be passed to Controller.Push interchangeably.
+package prompush
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	"go.uber.org/net/metrics/push"
+)
+
+const (
+	_defaultQueueSize       = 64
+	_defaultMaxRetries      = 3
+	_defaultInitialBackoff  = 100 * time.Millisecond
+	_defaultMaxBackoff      = 5 * time.Second
+	_defaultTimeout         = 10 * time.Second
+	_contentTypeProtobuf    = "application/x-protobuf"
+	_headerContentEncoding  = "Content-Encoding"
+	_headerRemoteWriteVersn = "X-Prometheus-Remote-Write-Version"
+	_remoteWriteVersion     = "0.1.0"
+)
+
+// Config configures a Pusher.
+type Config struct {
+	// Client sends the remote_write requests. Defaults to a client with a
+	// 10-second timeout.
+	Client *http.Client
+	// URL is the remote_write endpoint, e.g.
+	// "https://prometheus.example.com/api/v1/write".
+	URL string
+	// Username and Password, if either is set, are sent as HTTP basic auth.
+	Username string
+	Password string
+	// BearerToken, if set, is sent as an "Authorization: Bearer" header. It's
+	// mutually exclusive with Username/Password.
+	BearerToken string
+	// QueueSize bounds the number of pending pushes buffered in memory so
+	// that a slow or unavailable remote doesn't stall the scrape scheduler.
+	// Once the queue is full, Push drops the oldest pending batch. Defaults
+	// to 64.
+	QueueSize int
+	// MaxRetries bounds the number of retries made, after the first
+	// attempt, for a batch that receives a 5xx response or a network error.
+	// Defaults to 3.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry; each subsequent
+	// retry doubles the previous delay, capped at 5 seconds. Defaults to
+	// 100 milliseconds.
+	InitialBackoff time.Duration
+}
+
+// Pusher sends snapshots of registered metrics to a Prometheus remote_write
+// endpoint. Construct one with New and pass it to Controller.Push.
+type Pusher struct {
+	cfg   Config
+	queue chan *prompb.WriteRequest
+	done  chan struct{}
+}
+
+// New constructs a Pusher that ships metrics to the given remote_write
+// endpoint. The returned Pusher owns a background goroutine that drains its
+// queue and retries failed sends; callers don't need to manage it directly,
+// since Controller.Push already runs pushers on a ticker.
+func New(cfg Config) (*Pusher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("prompush: URL is required")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: _defaultTimeout}
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = _defaultQueueSize
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = _defaultMaxRetries
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = _defaultInitialBackoff
+	}
+
+	p := &Pusher{
+		cfg:   cfg,
+		queue: make(chan *prompb.WriteRequest, cfg.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go p.loop()
+	return p, nil
+}
+
+// Push implements push.Target. It translates the snapshot into a
+// remote_write WriteRequest and enqueues it for delivery; it never blocks on
+// network I/O.
+func (p *Pusher) Push(snap push.Snapshot) error {
+	wr := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(snap.Counters)+len(snap.Gauges)+4*len(snap.Histograms)),
+	}
+
+	now := timestampMillis()
+
+	for _, c := range snap.Counters {
+		wr.Timeseries = append(wr.Timeseries, series(c.Name, c.Labels, float64(c.Value), now))
+	}
+	for _, g := range snap.Gauges {
+		wr.Timeseries = append(wr.Timeseries, series(g.Name, g.Labels, g.Value, now))
+	}
+	for _, h := range snap.Histograms {
+		wr.Timeseries = append(wr.Timeseries, histogramSeries(h, now)...)
+	}
+
+	select {
+	case p.queue <- wr:
+		return nil
+	default:
+		// Queue is full: drop the oldest pending batch rather than block the
+		// scrape scheduler, and make room for this one.
+		select {
+		case <-p.queue:
+		default:
+		}
+		select {
+		case p.queue <- wr:
+		default:
+		}
+		return nil
+	}
+}
+
+// Close stops the background delivery goroutine. It does not flush the
+// queue; any batches still pending are dropped.
+func (p *Pusher) Close() error {
+	close(p.done)
+	return nil
+}
+
+func (p *Pusher) loop() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case wr := <-p.queue:
+			p.send(wr)
+		}
+	}
+}
+
+func (p *Pusher) send(wr *prompb.WriteRequest) {
+	body, err := proto.Marshal(wr)
+	if err != nil {
+		return
+	}
+	compressed := snappy.Encode(nil, body)
+
+	backoff := p.cfg.InitialBackoff
+	for attempt := 0; attempt <= p.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			}
+			backoff *= 2
+			if backoff > _defaultMaxBackoff {
+				backoff = _defaultMaxBackoff
+			}
+		}
+
+		ok, retryable := p.attempt(compressed)
+		if ok || !retryable {
+			return
+		}
+	}
+}
+
+// attempt makes a single delivery attempt, returning whether it succeeded
+// and whether a failure is worth retrying (5xx and network errors are
+// retryable; 4xx responses are not, since retrying won't change the
+// outcome).
+func (p *Pusher) attempt(compressed []byte) (ok, retryable bool) {
+	req, err := http.NewRequest(http.MethodPost, p.cfg.URL, bytes.NewReader(compressed))
+	if err != nil {
+		return false, false
+	}
+	req.Header.Set("Content-Type", _contentTypeProtobuf)
+	req.Header.Set(_headerContentEncoding, "snappy")
+	req.Header.Set(_headerRemoteWriteVersn, _remoteWriteVersion)
+	if p.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.BearerToken)
+	} else if p.cfg.Username != "" || p.cfg.Password != "" {
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return false, true
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return true, false
+	case resp.StatusCode/100 == 5:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func series(name string, labels map[string]string, value float64, timestampMs int64) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  promLabels(name, labels),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestampMs}},
+	}
+}
+
+// histogramSeries expands a single histogram into the usual Prometheus
+// trio: one timeseries per bucket (cumulative count, "le" label), plus
+// "_sum" and "_count". Buckets are cumulative, so _sum is approximated
+// from each bucket's own (non-cumulative) count, not its upper bound
+// times the running total - the latter compounds every bucket's count
+// into every larger bucket's weight and overflows once the "+Inf"
+// bucket (sentineled as math.MaxFloat64) holds any observations.
+func histogramSeries(h push.Histogram, timestampMs int64) []prompb.TimeSeries {
+	bounds := sortedBounds(h.Buckets)
+	out := make([]prompb.TimeSeries, 0, len(bounds)+2)
+
+	var sum float64
+	var floor int64
+	var lastFiniteBound float64
+	for _, upperBound := range bounds {
+		cumulative := h.Buckets[upperBound]
+		own := cumulative - floor
+		floor = cumulative
+
+		le := formatFloat(upperBound)
+		weight := upperBound
+		if isOverflowBound(upperBound) {
+			le = "+Inf"
+			// Overflow observations don't have a representable value;
+			// approximate with the largest finite bucket bound.
+			weight = lastFiniteBound
+		} else {
+			lastFiniteBound = upperBound
+		}
+		sum += weight * float64(own)
+
+		labels := promLabels(h.Name+"_bucket", h.Labels)
+		labels = append(labels, prompb.Label{Name: "le", Value: le})
+		out = append(out, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: float64(cumulative), Timestamp: timestampMs}},
+		})
+	}
+
+	out = append(out,
+		series(h.Name+"_sum", h.Labels, sum, timestampMs),
+		series(h.Name+"_count", h.Labels, float64(floor), timestampMs),
+	)
+	return out
+}
+
+// isOverflowBound reports whether b is the sentinel used for the "+Inf"
+// bucket.
+func isOverflowBound(b float64) bool {
+	return b == math.MaxFloat64
+}
+
+func promLabels(name string, labels map[string]string) []prompb.Label {
+	ls := make([]prompb.Label, 0, len(labels)+1)
+	ls = append(ls, prompb.Label{Name: "__name__", Value: name})
+	for k, v := range labels {
+		ls = append(ls, prompb.Label{Name: k, Value: v})
+	}
+	return ls
+}
+
+func timestampMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func sortedBounds(buckets map[float64]int64) []float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for b := range buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
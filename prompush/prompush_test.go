@@ -0,0 +1,167 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prompush
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/net/metrics/push"
+)
+
+func TestPusherSendsCountersGaugesAndHistograms(t *testing.T) {
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err, "Failed to read request body.")
+		body, err := snappy.Decode(nil, raw)
+		require.NoError(t, err, "Failed to decompress request body.")
+		var wr prompb.WriteRequest
+		require.NoError(t, proto.Unmarshal(body, &wr), "Failed to unmarshal WriteRequest.")
+		received.Store(wr)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	err = p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs_total", Labels: map[string]string{"service": "users"}, Value: 3}},
+		Gauges:   []push.Gauge{{Name: "inflight", Labels: map[string]string{"service": "users"}, Value: 4}},
+		Histograms: []push.Histogram{{
+			Name:    "latency_ms",
+			Labels:  map[string]string{"service": "users"},
+			Buckets: map[float64]int64{100: 1, 500: 2},
+		}},
+	})
+	require.NoError(t, err, "Push shouldn't fail to enqueue.")
+
+	assert.Eventually(t, func() bool {
+		wr, ok := received.Load().(prompb.WriteRequest)
+		// counter + gauge + 2 histogram buckets + _sum + _count
+		return ok && len(wr.Timeseries) == 6
+	}, time.Second, 10*time.Millisecond, "Server never received the expected write request.")
+}
+
+func TestPusherRetriesOn5xx(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL, InitialBackoff: time.Millisecond, MaxRetries: 5})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs_total", Value: 1}},
+	}))
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&attempts) >= 3
+	}, time.Second, 10*time.Millisecond, "Pusher didn't retry enough times.")
+}
+
+func TestPusherDoesNotRetryOn4xx(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL, InitialBackoff: time.Millisecond})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{Counters: []push.Counter{{Name: "reqs_total", Value: 1}}}))
+
+	// Give the background goroutine a moment to run, then confirm it only
+	// tried once.
+	time.Sleep(50 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&attempts), "Shouldn't retry non-5xx responses.")
+}
+
+func TestNewRequiresURL(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err, "Expected an error when URL is unset.")
+}
+
+func TestHistogramSeriesOverflowBucket(t *testing.T) {
+	series := histogramSeries(push.Histogram{
+		Name:    "latency_ms",
+		Buckets: map[float64]int64{100: 1, 500: 3, math.MaxFloat64: 5},
+	}, 0)
+
+	var sawInf bool
+	for _, s := range series {
+		for _, l := range s.Labels {
+			if l.Name != "le" {
+				continue
+			}
+			assert.NotContains(t, l.Value, "e+", "le label should never render the raw float sentinel.")
+			if l.Value == "+Inf" {
+				sawInf = true
+			}
+		}
+	}
+	assert.True(t, sawInf, "Overflow bucket should be labeled le=\"+Inf\".")
+
+	sum := findSeries(t, series, "latency_ms_sum").Samples[0].Value
+	// own counts: 1 in the 100 bucket, 2 in the 500 bucket, 2 overflow -
+	// overflow observations are approximated at the largest finite bound.
+	assert.Equal(t, 100*1+500*2+500*2, int(sum), "Wrong _sum: overflow bucket must not contribute math.MaxFloat64.")
+
+	count := findSeries(t, series, "latency_ms_count").Samples[0].Value
+	assert.Equal(t, float64(5), count, "Wrong _count.")
+}
+
+func findSeries(t *testing.T, series []prompb.TimeSeries, name string) prompb.TimeSeries {
+	t.Helper()
+	for _, s := range series {
+		for _, l := range s.Labels {
+			if l.Name == "__name__" && l.Value == name {
+				return s
+			}
+		}
+	}
+	t.Fatalf("no series named %q", name)
+	return prompb.TimeSeries{}
+}
+
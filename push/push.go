@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package push defines the interface and data types that Controller.Push
+// uses to ship metric snapshots to a push-based backend (StatsD, Tally,
+// Prometheus remote_write, and so on).
+package push
+
+import "time"
+
+// Target receives periodic snapshots of a Registry's metrics. Controller.Push
+// calls Push on a timer; implementations should return quickly, queuing or
+// batching network I/O rather than blocking the calling goroutine.
+type Target interface {
+	Push(Snapshot) error
+}
+
+// Counter is a point-in-time reading of a single counter (scalar or vector
+// child), including its fully resolved constant and variable labels.
+type Counter struct {
+	Name   string
+	Labels map[string]string
+	Value  int64
+}
+
+// Gauge is a point-in-time reading of a single gauge.
+type Gauge struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Histogram is a point-in-time reading of a single histogram. Buckets maps
+// each configured bucket's upper bound (in multiples of Unit) to the
+// cumulative number of observations less than or equal to it; the overflow
+// ("+Inf") bucket is keyed by math.MaxFloat64.
+type Histogram struct {
+	Name    string
+	Labels  map[string]string
+	Unit    time.Duration
+	Buckets map[float64]int64
+}
+
+// Summary is a point-in-time reading of a single summary: the count and sum
+// of the observations in its rolling MaxAge window, plus its current
+// per-objective quantile estimates.
+type Summary struct {
+	Name      string
+	Labels    map[string]string
+	Sum       float64
+	Count     int64
+	Quantiles map[float64]float64
+}
+
+// Snapshot is a point-in-time reading of every push-enabled metric in a
+// Registry.
+type Snapshot struct {
+	Counters   []Counter
+	Gauges     []Gauge
+	Histograms []Histogram
+	Summaries  []Summary
+}
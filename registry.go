@@ -0,0 +1,305 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metrics is a metrics façade: it provides a small set of metric
+// types (Counter, Gauge, Histogram, Summary, and their vector variants),
+// enforces a single naming and labeling convention across them, and
+// exposes the result for both pull-based scraping (Prometheus/OpenMetrics)
+// and push-based delivery (see the push, tallypush, prompush, and
+// statsdpush packages).
+package metrics
+
+import (
+	"fmt"
+	"sync"
+)
+
+type metricKind uint8
+
+const (
+	kindCounter metricKind = iota
+	kindGauge
+	kindHistogram
+	kindSummary
+)
+
+func (k metricKind) String() string {
+	switch k {
+	case kindCounter:
+		return "counter"
+	case kindGauge:
+		return "gauge"
+	case kindHistogram:
+		return "histogram"
+	case kindSummary:
+		return "summary"
+	default:
+		return "unknown"
+	}
+}
+
+func vectorOrScalar(isVector bool) string {
+	if isVector {
+		return "vector"
+	}
+	return "scalar"
+}
+
+// scalarInstance is one registered scalar metric: it shares its family's
+// name, help text, and dimensions, but has its own concrete constant-label
+// values.
+type scalarInstance struct {
+	labels      Labels
+	disablePush bool
+	metric      interface{} // *Counter, *Gauge, *Histogram, or *Summary
+}
+
+// vectorInstance is one registered metric vector: it shares its family's
+// name, help text, and dimensions, but has its own concrete constant-label
+// values (its variable labels are, by definition, not yet resolved to
+// values).
+type vectorInstance struct {
+	labels         Labels
+	variableLabels []string
+	disablePush    bool
+	vector         interface{} // *CounterVector, *GaugeVector, *HistogramVector, or *SummaryVector
+}
+
+// family groups every registered instance (scalar or vector) that shares a
+// metric name. All instances in a family must agree on help text, kind,
+// scalar/vector-ness, and dimensions (the set of constant label keys plus
+// variable label names); they may differ in their constant label values,
+// which lets callers build up what's effectively a vector out of
+// individually registered scalars.
+type family struct {
+	name      string
+	help      string
+	kind      metricKind
+	isVector  bool
+	dims      map[string]struct{}
+	valueKeys map[string]struct{}
+
+	scalars []*scalarInstance
+	vectors []*vectorInstance
+}
+
+// registryCore is the state shared by a Root and every Registry derived
+// from it via Labeled: metric families are tracked here so that uniqueness
+// is enforced across the whole tree, not just within one scoped Registry.
+type registryCore struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// Registry is where metrics are created, and the unit of constant-label
+// scoping: every metric created on a Registry carries that Registry's
+// labels (see Labeled) in addition to its own. The zero value isn't
+// usable; construct one with New.
+type Registry struct {
+	core   *registryCore
+	labels Labels
+}
+
+// New constructs a Registry and the Controller used to scrape or push its
+// metrics. Metrics registered directly on the returned Registry carry no
+// additional constant labels; use Labeled to scope a subtree of metrics to
+// a service, component, or shard.
+func New() (*Registry, *Controller) {
+	core := &registryCore{families: make(map[string]*family)}
+	return &Registry{core: core}, &Controller{core: core}
+}
+
+// Labeled returns a Registry that behaves like r, except that every metric
+// it creates also carries the supplied constant labels (merged with any
+// labels r already applies; labels passed here win on collision).
+func (r *Registry) Labeled(labels Labels) *Registry {
+	scrubbed, err := scrubLabels(labels)
+	if err != nil {
+		// Labeled has no error return (scoping a registry is meant to be a
+		// one-line, infallible call); a scrubbing collision here simply
+		// surfaces as a registration failure on the first metric that hits
+		// it, same as any other malformed Labels.
+		scrubbed = labels
+	}
+	return &Registry{core: r.core, labels: mergeLabels(r.labels, scrubbed)}
+}
+
+// register validates opts against this Registry's identity rules and, on
+// success, reserves a slot for a new instance of the family named
+// opts.Name. It returns the fully resolved (registry-scope-merged,
+// scrubbed) constant labels and scrubbed variable label names; callers
+// construct the concrete metric or vector and then call attachScalar or
+// attachVector to make it visible to scrapes and pushes.
+func (r *Registry) register(opts Opts, isVector bool, kind metricKind) (Labels, []string, *family, error) {
+	name := scrub(opts.Name)
+
+	ownLabels, err := scrubLabels(opts.Labels)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	variableLabels, err := scrubVariableLabels(opts.VariableLabels)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Labeled keeps scope labels unscrubbed when they collide after
+	// scrubbing; rescrubbing them here turns that collision into an error.
+	scope, err := scrubLabels(r.labels)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	labels := mergeLabels(scope, ownLabels)
+	dims := dimensionSet(labels, variableLabels)
+
+	r.core.mu.Lock()
+	defer r.core.mu.Unlock()
+
+	fam, ok := r.core.families[name]
+	if !ok {
+		fam = &family{
+			name:      name,
+			help:      opts.Help,
+			kind:      kind,
+			isVector:  isVector,
+			dims:      dims,
+			valueKeys: make(map[string]struct{}),
+		}
+		r.core.families[name] = fam
+	} else {
+		if fam.help != opts.Help {
+			return nil, nil, nil, fmt.Errorf("metrics: %q already registered with different help text", name)
+		}
+		if fam.kind != kind {
+			return nil, nil, nil, fmt.Errorf("metrics: %q already registered as a %s", name, fam.kind)
+		}
+		if fam.isVector != isVector {
+			return nil, nil, nil, fmt.Errorf("metrics: %q already registered as a %s", name, vectorOrScalar(fam.isVector))
+		}
+		if !dimensionSetsEqual(fam.dims, dims) {
+			return nil, nil, nil, fmt.Errorf("metrics: %q already registered with different dimensions", name)
+		}
+	}
+
+	key := labelValueKey(labels)
+	if _, dup := fam.valueKeys[key]; dup {
+		// Unlike the mismatches above, fam is still meaningful here: it's
+		// the same family the caller asked for, just with this exact
+		// label set already taken. Returning it lets callers that expect
+		// - and can tolerate - this specific collision (e.g. the shared
+		// cardinality-limit meta-counter) look up the existing instance
+		// instead of treating every error alike.
+		return labels, variableLabels, fam, fmt.Errorf("metrics: %q already registered with these label values", name)
+	}
+	fam.valueKeys[key] = struct{}{}
+
+	return labels, variableLabels, fam, nil
+}
+
+func (r *Registry) attachScalar(fam *family, labels Labels, disablePush bool, metric interface{}) {
+	r.core.mu.Lock()
+	fam.scalars = append(fam.scalars, &scalarInstance{labels: labels, disablePush: disablePush, metric: metric})
+	r.core.mu.Unlock()
+}
+
+func (r *Registry) attachVector(fam *family, labels Labels, variableLabels []string, disablePush bool, vector interface{}) {
+	r.core.mu.Lock()
+	fam.vectors = append(fam.vectors, &vectorInstance{
+		labels:         labels,
+		variableLabels: variableLabels,
+		disablePush:    disablePush,
+		vector:         vector,
+	})
+	r.core.mu.Unlock()
+}
+
+// NewCounter registers and returns a new Counter.
+func (r *Registry) NewCounter(opts Opts) (*Counter, error) {
+	labels, _, fam, err := r.register(opts, false /* isVector */, kindCounter)
+	if err != nil {
+		return nil, err
+	}
+	c := &Counter{}
+	r.attachScalar(fam, labels, opts.DisablePush, c)
+	return c, nil
+}
+
+// NewCounterVector registers and returns a new CounterVector.
+func (r *Registry) NewCounterVector(opts Opts) (*CounterVector, error) {
+	labels, variableLabels, fam, err := r.register(opts, true /* isVector */, kindCounter)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newVectorStoreFromOpts(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	v := &CounterVector{variableLabels: variableLabels, store: store}
+	r.attachVector(fam, labels, variableLabels, opts.DisablePush, v)
+	return v, nil
+}
+
+// NewGauge registers and returns a new Gauge.
+func (r *Registry) NewGauge(opts Opts) (*Gauge, error) {
+	labels, _, fam, err := r.register(opts, false /* isVector */, kindGauge)
+	if err != nil {
+		return nil, err
+	}
+	g := &Gauge{}
+	r.attachScalar(fam, labels, opts.DisablePush, g)
+	return g, nil
+}
+
+// NewGaugeVector registers and returns a new GaugeVector.
+func (r *Registry) NewGaugeVector(opts Opts) (*GaugeVector, error) {
+	labels, variableLabels, fam, err := r.register(opts, true /* isVector */, kindGauge)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newVectorStoreFromOpts(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	v := &GaugeVector{variableLabels: variableLabels, store: store}
+	r.attachVector(fam, labels, variableLabels, opts.DisablePush, v)
+	return v, nil
+}
+
+// NewHistogram registers and returns a new Histogram.
+func (r *Registry) NewHistogram(opts HistogramOpts) (*Histogram, error) {
+	opts = opts.withDefaults()
+	labels, _, fam, err := r.register(opts.Opts, false /* isVector */, kindHistogram)
+	if err != nil {
+		return nil, err
+	}
+	h := newHistogram(opts)
+	r.attachScalar(fam, labels, opts.DisablePush, h)
+	return h, nil
+}
+
+// NewHistogramVector registers and returns a new HistogramVector.
+func (r *Registry) NewHistogramVector(opts HistogramOpts) (*HistogramVector, error) {
+	opts = opts.withDefaults()
+	labels, variableLabels, fam, err := r.register(opts.Opts, true /* isVector */, kindHistogram)
+	if err != nil {
+		return nil, err
+	}
+	v := &HistogramVector{variableLabels: variableLabels, opts: opts, children: make(map[string]*Histogram)}
+	r.attachVector(fam, labels, variableLabels, opts.DisablePush, v)
+	return v, nil
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package statsdpush pushes metrics to a StatsD or DogStatsD agent over
+// UDP (or a Unix domain socket). It's a sibling of tallypush and prompush:
+// all three implement push.Target, so they can be passed to
+// Controller.Push interchangeably.
+package statsdpush
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/net/metrics/push"
+)
+
+// Default MTU for batching multiple samples into a single UDP datagram.
+// 1432 leaves room for IP/UDP headers within a standard 1500-byte Ethernet
+// frame without fragmenting.
+const _defaultMTU = 1432
+
+// Network selects the transport Pusher dials.
+type Network string
+
+const (
+	// UDP sends each batch as a single UDP datagram. This is the default.
+	UDP Network = "udp"
+	// UnixgramSocket sends each batch over a Unix domain datagram socket,
+	// for agents running on the same host.
+	UnixgramSocket Network = "unixgram"
+)
+
+// Config configures a Pusher.
+type Config struct {
+	// Addr is the destination address: a "host:port" pair for UDP, or a
+	// socket path for UnixgramSocket.
+	Addr string
+	// Network selects the transport. Defaults to UDP.
+	Network Network
+	// DogStatsD switches on Datadog's tag extension, appending constant and
+	// variable labels as "|#tag1:val1,tag2:val2" on every line.
+	DogStatsD bool
+	// MTU bounds the size of a single datagram; samples are batched up to
+	// this size, newline-separated, before a new datagram is started.
+	// Defaults to 1432.
+	MTU int
+}
+
+// Pusher sends snapshots of registered metrics to a StatsD-compatible
+// agent. Construct one with New and pass it to Controller.Push.
+//
+// Counters and histogram buckets are cumulative in every push.Snapshot,
+// but StatsD's "|c" and "|h"/"|ms" lines are additive - so Pusher
+// remembers what it last reported for each metric and only pushes the
+// delta, rather than re-reporting the running total (or the metric's
+// entire observation history) on every tick. This mirrors tallypush's
+// target, which solves the same problem for Tally scopes.
+type Pusher struct {
+	cfg  Config
+	conn net.Conn
+
+	mu         sync.Mutex
+	counters   map[string]int64
+	histograms map[string]map[float64]int64
+}
+
+// New dials the configured StatsD agent and returns a Pusher ready to use.
+func New(cfg Config) (*Pusher, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("statsdpush: Addr is required")
+	}
+	if cfg.Network == "" {
+		cfg.Network = UDP
+	}
+	if cfg.MTU <= 0 {
+		cfg.MTU = _defaultMTU
+	}
+
+	conn, err := net.Dial(string(cfg.Network), cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsdpush: dial %s: %w", cfg.Addr, err)
+	}
+
+	return &Pusher{
+		cfg:        cfg,
+		conn:       conn,
+		counters:   make(map[string]int64),
+		histograms: make(map[string]map[float64]int64),
+	}, nil
+}
+
+// Close releases the underlying socket.
+func (p *Pusher) Close() error {
+	return p.conn.Close()
+}
+
+// Push implements push.Target. It serializes the snapshot's metrics into
+// StatsD lines and batches them into as few datagrams as the configured
+// MTU allows.
+func (p *Pusher) Push(snap push.Snapshot) error {
+	var batch strings.Builder
+
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		_, err := p.conn.Write([]byte(batch.String()))
+		batch.Reset()
+		return err
+	}
+
+	appendLine := func(line string) error {
+		// +1 for the newline that separates lines within a datagram.
+		if batch.Len() > 0 && batch.Len()+len(line)+1 > p.cfg.MTU {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if batch.Len() > 0 {
+			batch.WriteByte('\n')
+		}
+		batch.WriteString(line)
+		return nil
+	}
+
+	for _, c := range snap.Counters {
+		if line, ok := p.counterLine(c); ok {
+			if err := appendLine(line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, g := range snap.Gauges {
+		if err := appendLine(p.gaugeLine(g)); err != nil {
+			return err
+		}
+	}
+	for _, h := range snap.Histograms {
+		for _, line := range p.histogramLines(h) {
+			if err := appendLine(line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range snap.Summaries {
+		if line, ok := p.summaryLine(s); ok {
+			if err := appendLine(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// counterLine reports the delta between c's cumulative value and what was
+// last pushed for this counter, so that repeated pushes don't resend the
+// counter's entire lifetime total as a new increment every tick. ok is
+// false (and the line should be skipped) when there's nothing new to
+// report.
+func (p *Pusher) counterLine(c push.Counter) (line string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.counters == nil {
+		p.counters = make(map[string]int64)
+	}
+	key := metricKey(c.Name, c.Labels)
+	delta := c.Value - p.counters[key]
+	p.counters[key] = c.Value
+	if delta <= 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%d|c%s", c.Name, delta, p.tagSuffix(c.Labels)), true
+}
+
+func (p *Pusher) gaugeLine(g push.Gauge) string {
+	return fmt.Sprintf("%s:%s|g%s", g.Name, formatFloat(g.Value), p.tagSuffix(g.Labels))
+}
+
+// histogramLines emits one "|h" (or "|ms" for time-based histograms) line
+// per observation implied by the bucket counts since the last push: each
+// bucket's count is cumulative (the number of observations less than or
+// equal to its upper bound) for the histogram's entire lifetime, so the
+// count attributable to a single push is the bucket's own count (the
+// difference from the previous, smaller bucket) minus what was already
+// reported for it last time - otherwise every historical observation
+// would be re-emitted as a new sample on every tick.
+func (p *Pusher) histogramLines(h push.Histogram) []string {
+	typ := "h"
+	if h.Unit > 0 {
+		typ = "ms"
+	}
+
+	p.mu.Lock()
+	if p.histograms == nil {
+		p.histograms = make(map[string]map[float64]int64)
+	}
+	key := metricKey(h.Name, h.Labels)
+	last := p.histograms[key]
+	p.histograms[key] = h.Buckets
+	p.mu.Unlock()
+
+	bounds := sortedBounds(h.Buckets)
+	lines := make([]string, 0, len(bounds))
+	var floor, lastFloor int64
+	var lastFiniteValue float64
+	for _, upperBound := range bounds {
+		own := h.Buckets[upperBound] - floor
+		floor = h.Buckets[upperBound]
+
+		lastOwn := last[upperBound] - lastFloor
+		lastFloor = last[upperBound]
+
+		value := upperBound
+		if h.Unit > 0 {
+			// Buckets are expressed as multiples of h.Unit; StatsD's "ms"
+			// type always wants milliseconds, regardless of the
+			// histogram's configured unit.
+			value = upperBound * float64(h.Unit) / float64(time.Millisecond)
+		}
+		if isOverflowBound(upperBound) {
+			// Overflow observations don't have a representable value;
+			// approximate with the largest finite bucket's value instead
+			// of emitting the raw math.MaxFloat64 sentinel.
+			value = lastFiniteValue
+		} else {
+			lastFiniteValue = value
+		}
+
+		delta := own - lastOwn
+		if delta <= 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s:%s|%s%s", h.Name, formatFloat(value), typ, p.tagSuffix(h.Labels))
+		for i := int64(0); i < delta; i++ {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// summaryLine approximates a Summary as a single StatsD histogram sample
+// per push, equal to the mean of the values in the summary's MaxAge window
+// (ok is false, and the line should be skipped, if there are none). A
+// Summary's Sum and Count describe a rolling MaxAge window rather than a
+// running total, so - unlike counters and histogram buckets - they can't
+// be delta-tracked between pushes.
+func (p *Pusher) summaryLine(s push.Summary) (line string, ok bool) {
+	if s.Count == 0 {
+		return "", false
+	}
+	mean := s.Sum / float64(s.Count)
+	return fmt.Sprintf("%s:%s|h%s", s.Name, formatFloat(mean), p.tagSuffix(s.Labels)), true
+}
+
+// isOverflowBound reports whether b is the sentinel used for the "+Inf"
+// bucket.
+func isOverflowBound(b float64) bool {
+	return b == math.MaxFloat64
+}
+
+// tagSuffix renders DogStatsD tags from constant and variable labels. It's
+// a no-op in vanilla StatsD mode.
+func (p *Pusher) tagSuffix(labels map[string]string) string {
+	if !p.cfg.DogStatsD || len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	// Sort for deterministic output, which makes this package's own tests
+	// (and debugging a tcpdump) much easier to reason about.
+	sort.Strings(keys)
+
+	tags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, k+":"+labels[k])
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+func sortedBounds(buckets map[float64]int64) []float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for b := range buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// metricKey identifies a metric for delta-tracking purposes: its name plus
+// its fully resolved label values, since two metrics with the same name
+// but different label values are tracked independently.
+func metricKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		} else {
+			b.WriteByte('+')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
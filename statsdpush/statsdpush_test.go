@@ -0,0 +1,195 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsdpush
+
+import (
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.uber.org/net/metrics/push"
+)
+
+func listenUDP(t testing.TB) (addr string, recv func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to open UDP listener.")
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() string {
+		buf := make([]byte, 65536)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err, "Didn't receive a datagram.")
+		return string(buf[:n])
+	}
+}
+
+func TestPusherSerializesCounterAndGauge(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs", Value: 3}},
+		Gauges:   []push.Gauge{{Name: "inflight", Value: 4}},
+	}))
+
+	got := recv()
+	assert.Contains(t, got, "reqs:3|c")
+	assert.Contains(t, got, "inflight:4|g")
+}
+
+func TestPusherDogStatsDTags(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr, DogStatsD: true})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs", Value: 1, Labels: map[string]string{"service": "users"}}},
+	}))
+
+	assert.Equal(t, "reqs:1|c|#service:users", recv())
+}
+
+func TestPusherHistogramUsesMillisecondUnit(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Histograms: []push.Histogram{{
+			Name:    "latency",
+			Unit:    time.Millisecond,
+			Buckets: map[float64]int64{1000: 1},
+		}},
+	}))
+
+	assert.Contains(t, recv(), "latency:1000|ms")
+}
+
+func TestPusherBatchesUpToMTU(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr, MTU: 32})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{
+			{Name: "aaaaaaaaaaaaaaaaaaaa", Value: 1},
+			{Name: "bbbbbbbbbbbbbbbbbbbb", Value: 2},
+		},
+	}))
+
+	first := recv()
+	assert.True(t, strings.HasPrefix(first, "aaaaaaaaaaaaaaaaaaaa:1|c"), "First datagram should contain only the first counter.")
+
+	second := recv()
+	assert.True(t, strings.HasPrefix(second, "bbbbbbbbbbbbbbbbbbbb:2|c"), "Second datagram should contain the overflow counter.")
+}
+
+func TestPusherCountersAreDeltaTracked(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs", Value: 3}},
+	}))
+	assert.Equal(t, "reqs:3|c", recv(), "First push should report the full cumulative value.")
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Counters: []push.Counter{{Name: "reqs", Value: 8}},
+	}))
+	assert.Equal(t, "reqs:5|c", recv(), "Second push should report only the delta, not the new running total.")
+}
+
+func TestPusherHistogramsAreDeltaTracked(t *testing.T) {
+	p := &Pusher{}
+
+	first := p.histogramLines(push.Histogram{
+		Name:    "latency",
+		Buckets: map[float64]int64{10: 2, math.MaxFloat64: 4},
+	})
+	assert.Len(t, first, 4, "First push should report every historical observation.")
+
+	second := p.histogramLines(push.Histogram{
+		Name:    "latency",
+		Buckets: map[float64]int64{10: 2, math.MaxFloat64: 4},
+	})
+	assert.Empty(t, second, "Unchanged cumulative counts should produce no lines on the next push.")
+
+	third := p.histogramLines(push.Histogram{
+		Name:    "latency",
+		Buckets: map[float64]int64{10: 3, math.MaxFloat64: 4},
+	})
+	require.Len(t, third, 1, "Only the single new observation should be reported.")
+	assert.Equal(t, "latency:10|h", third[0])
+}
+
+func TestPusherSummaryReportsMean(t *testing.T) {
+	addr, recv := listenUDP(t)
+	p, err := New(Config{Addr: addr})
+	require.NoError(t, err, "Failed to construct Pusher.")
+	defer p.Close()
+
+	require.NoError(t, p.Push(push.Snapshot{
+		Summaries: []push.Summary{{Name: "latency", Sum: 30, Count: 3}},
+	}))
+	assert.Equal(t, "latency:10|h", recv())
+}
+
+func TestPusherSummaryWithNoObservationsProducesNoLine(t *testing.T) {
+	p := &Pusher{}
+
+	line, ok := p.summaryLine(push.Summary{Name: "latency", Sum: 0, Count: 0})
+	assert.False(t, ok, "A summary with no observations shouldn't push a line.")
+	assert.Empty(t, line)
+}
+
+func TestHistogramLinesOverflowBucket(t *testing.T) {
+	p := &Pusher{}
+
+	lines := p.histogramLines(push.Histogram{
+		Name:    "latency",
+		Unit:    time.Millisecond,
+		Buckets: map[float64]int64{1000: 1, math.MaxFloat64: 2},
+	})
+	require.Len(t, lines, 2, "Expected one line per observation, including the overflow one.")
+	for _, line := range lines {
+		assert.Equal(t, "latency:1000|ms", line, "Overflow observation should be approximated at the largest finite bound, not math.MaxFloat64.")
+	}
+
+	lines = p.histogramLines(push.Histogram{
+		Name:    "unitless",
+		Buckets: map[float64]int64{10: 1, math.MaxFloat64: 1},
+	})
+	require.Len(t, lines, 1)
+	assert.Equal(t, "unitless:10|h", lines[0])
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beorn7/perks/quantile"
+)
+
+// SummaryOpts configures a Summary or SummaryVector. Unlike histograms,
+// summaries compute their quantiles client-side, so there's no need to pick
+// bucket boundaries in advance - but the quantiles can't be aggregated
+// across processes the way bucketed histogram counts can.
+type SummaryOpts struct {
+	Opts
+
+	// Objectives maps each target quantile (e.g., 0.5 for the median) to
+	// the rank error it's allowed to have (e.g., 0.05). Smaller rank errors
+	// cost more memory and CPU to maintain. Defaults to
+	// {0.5: 0.05, 0.9: 0.01, 0.99: 0.001}.
+	Objectives map[float64]float64
+	// MaxAge is the duration for which observations stay eligible for
+	// inclusion in the summary. Defaults to 10 minutes.
+	MaxAge time.Duration
+	// AgeBuckets is the number of rotating buckets used to discard
+	// observations older than MaxAge. Defaults to 5.
+	AgeBuckets int
+	// BufCap defaults to 500. It isn't passed to the underlying quantile
+	// streams, so it doesn't change how their inserts are buffered.
+	BufCap uint32
+}
+
+const (
+	_defaultMaxAge     = 10 * time.Minute
+	_defaultAgeBuckets = 5
+	_defaultBufCap     = uint32(500)
+)
+
+var _defaultObjectives = map[float64]float64{
+	0.5:  0.05,
+	0.9:  0.01,
+	0.99: 0.001,
+}
+
+func (o *SummaryOpts) withDefaults() SummaryOpts {
+	cp := *o
+	if cp.Objectives == nil {
+		cp.Objectives = _defaultObjectives
+	}
+	if cp.MaxAge <= 0 {
+		cp.MaxAge = _defaultMaxAge
+	}
+	if cp.AgeBuckets <= 0 {
+		cp.AgeBuckets = _defaultAgeBuckets
+	}
+	if cp.BufCap <= 0 {
+		cp.BufCap = _defaultBufCap
+	}
+	return cp
+}
+
+type summaryBucket struct {
+	stream *quantile.Stream
+	sum    float64
+	count  uint64
+}
+
+// Summary observes samples into a ring of age buckets: the newest
+// ("head") bucket takes every Observe, and once it's accumulated a full
+// MaxAge/AgeBuckets tick's worth of observations, the oldest bucket is
+// reset and promoted to be the new head. Querying merges every bucket, so
+// the result reflects roughly the trailing MaxAge window without ever
+// rewriting already-inserted samples. This mirrors the scheme used by the
+// Prometheus client's summary implementation.
+type Summary struct {
+	opts SummaryOpts
+
+	mu       sync.Mutex
+	buckets  []*summaryBucket
+	headIdx  int
+	headExp  time.Time
+	tickSize time.Duration
+}
+
+func newSummary(opts SummaryOpts) *Summary {
+	opts = opts.withDefaults()
+	s := &Summary{
+		opts:     opts,
+		buckets:  make([]*summaryBucket, opts.AgeBuckets),
+		tickSize: opts.MaxAge / time.Duration(opts.AgeBuckets),
+	}
+	for i := range s.buckets {
+		s.buckets[i] = s.newBucket()
+	}
+	s.headExp = time.Now().Add(s.tickSize)
+	return s
+}
+
+func (s *Summary) newBucket() *summaryBucket {
+	return &summaryBucket{stream: quantile.NewTargeted(s.opts.Objectives)}
+}
+
+// Observe records a value in the current head bucket. DisablePush doesn't
+// affect it; that setting only excludes the summary from Controller.Push.
+func (s *Summary) Observe(value float64) {
+	s.mu.Lock()
+	s.maybeRotate()
+	head := s.buckets[s.headIdx]
+	head.stream.Insert(value)
+	head.sum += value
+	head.count++
+	s.mu.Unlock()
+}
+
+// maybeRotate advances the head bucket once it's aged past a full tick,
+// resetting the bucket it leaves behind so it's ready to become the new
+// head a full window later.
+func (s *Summary) maybeRotate() {
+	if s.tickSize <= 0 {
+		return
+	}
+	for time.Now().After(s.headExp) {
+		s.headIdx = (s.headIdx + 1) % len(s.buckets)
+		*s.buckets[s.headIdx] = *s.newBucket()
+		s.headExp = s.headExp.Add(s.tickSize)
+	}
+}
+
+// snapshot returns the merged bucket set's sum, count, and per-objective
+// quantile values.
+func (s *Summary) snapshot() (sum float64, count uint64, quantiles map[float64]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maybeRotate()
+
+	merged := quantile.NewTargeted(s.opts.Objectives)
+	for _, b := range s.buckets {
+		merged.Merge(b.stream.Samples())
+		sum += b.sum
+		count += b.count
+	}
+
+	quantiles = make(map[float64]float64, len(s.opts.Objectives))
+	for q := range s.opts.Objectives {
+		quantiles[q] = merged.Query(q)
+	}
+	return sum, count, quantiles
+}
+
+// SummaryVector is a collection of Summaries that share a name and help
+// text but are partitioned by the values of their variable labels, just
+// like HistogramVector.
+type SummaryVector struct {
+	opts           SummaryOpts
+	variableLabels []string
+
+	mu        sync.RWMutex
+	summaries map[string]*Summary
+}
+
+func newSummaryVector(opts SummaryOpts, variableLabels []string) *SummaryVector {
+	return &SummaryVector{
+		opts:           opts.withDefaults(),
+		variableLabels: variableLabels,
+		summaries:      make(map[string]*Summary),
+	}
+}
+
+// MustGet retrieves (creating if necessary) the Summary for the supplied
+// variable label values, given as alternating label names and values in
+// the same order as the vector's VariableLabels. It panics if the keyvals
+// don't match the vector's VariableLabels, exactly as CounterVector.MustGet
+// does.
+func (v *SummaryVector) MustGet(keyvals ...string) *Summary {
+	key := vectorChildKey(vectorValues(v.variableLabels, keyvals))
+
+	v.mu.RLock()
+	s, ok := v.summaries[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.summaries[key]; ok {
+		return s
+	}
+	s = newSummary(v.opts)
+	v.summaries[key] = s
+	return s
+}
+
+// each calls fn once per live child, passing the variable label values (in
+// VariableLabels order) that produced it.
+func (v *SummaryVector) each(fn func(values []string, s *Summary)) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for key, s := range v.summaries {
+		fn(strings.Split(key, "\xff"), s)
+	}
+}
+
+// writeProm renders the summary as Prometheus text exposition lines: one
+// line per target quantile, plus "_sum" and "_count". labels are the
+// metric's already-scrubbed constant and variable labels, not including
+// "quantile" - writeProm adds that itself for each target.
+func (s *Summary) writeProm(w io.Writer, name string, labels Labels) error {
+	sum, count, quantiles := s.snapshot()
+
+	targets := make([]float64, 0, len(quantiles))
+	for q := range quantiles {
+		targets = append(targets, q)
+	}
+	sort.Float64s(targets)
+
+	for _, q := range targets {
+		withQuantile := make(Labels, len(labels)+1)
+		for k, v := range labels {
+			withQuantile[k] = v
+		}
+		withQuantile["quantile"] = fmt.Sprintf("%g", q)
+		if _, err := fmt.Fprintf(w, "%s%s %g\n", name, promLabelString(withQuantile), quantiles[q]); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum%s %g\n", name, promLabelString(labels), sum); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count%s %d\n", name, promLabelString(labels), count)
+	return err
+}
+
+// promLabelString renders labels in Prometheus text exposition order
+// (sorted by key, for deterministic output), e.g. `{foo="bar",quux="baz"}`.
+func promLabelString(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// NewSummary registers and returns a new Summary, following the same
+// identity and duplicate-detection rules as NewHistogram.
+func (r *Registry) NewSummary(opts SummaryOpts) (*Summary, error) {
+	opts = opts.withDefaults()
+	labels, _, fam, err := r.register(opts.Opts, false /* isVector */, kindSummary)
+	if err != nil {
+		return nil, err
+	}
+	s := newSummary(opts)
+	r.attachScalar(fam, labels, opts.DisablePush, s)
+	return s, nil
+}
+
+// NewSummaryVector registers and returns a new SummaryVector, following the
+// same identity and duplicate-detection rules as NewHistogramVector.
+func (r *Registry) NewSummaryVector(opts SummaryOpts) (*SummaryVector, error) {
+	opts = opts.withDefaults()
+	labels, variableLabels, fam, err := r.register(opts.Opts, true /* isVector */, kindSummary)
+	if err != nil {
+		return nil, err
+	}
+	v := newSummaryVector(opts, variableLabels)
+	r.attachVector(fam, labels, variableLabels, opts.DisablePush, v)
+	return v, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryQuantiles(t *testing.T) {
+	s := newSummary(SummaryOpts{
+		Objectives: map[float64]float64{0.5: 0.05, 0.99: 0.001},
+	})
+	for i := 1; i <= 100; i++ {
+		s.Observe(float64(i))
+	}
+
+	sum, count, quantiles := s.snapshot()
+	assert.EqualValues(t, 100, count, "Wrong observation count.")
+	assert.Equal(t, float64(5050), sum, "Wrong sum.")
+	assert.InDelta(t, 50, quantiles[0.5], 6, "Median too far from expected value.")
+	assert.InDelta(t, 99, quantiles[0.99], 2, "p99 too far from expected value.")
+}
+
+func TestSummaryAgesOutOldObservations(t *testing.T) {
+	s := newSummary(SummaryOpts{
+		MaxAge:     40 * time.Millisecond,
+		AgeBuckets: 4,
+	})
+	s.Observe(1000)
+
+	require.Eventually(t, func() bool {
+		_, count, _ := s.snapshot()
+		return count == 0
+	}, time.Second, 5*time.Millisecond, "Old observations should eventually age out.")
+}
+
+func TestSummaryVectorMustGet(t *testing.T) {
+	v := newSummaryVector(SummaryOpts{}, []string{"route"})
+	a := v.MustGet("route", "/foo")
+	b := v.MustGet("route", "/foo")
+	c := v.MustGet("route", "/bar")
+
+	assert.Same(t, a, b, "MustGet should return the same Summary for identical label values.")
+	assert.NotSame(t, a, c, "MustGet should return distinct Summaries for distinct label values.")
+}
+
+func TestSummaryWriteProm(t *testing.T) {
+	s := newSummary(SummaryOpts{Objectives: map[float64]float64{0.5: 0.05}})
+	s.Observe(1)
+	s.Observe(2)
+	s.Observe(3)
+
+	var buf strings.Builder
+	require.NoError(t, s.writeProm(&buf, "latency", Labels{"service": "users"}))
+
+	out := buf.String()
+	assert.Contains(t, out, `latency{quantile="0.5",service="users"}`, "Missing quantile line.")
+	assert.Contains(t, out, `latency_sum{service="users"} 6`, "Missing or wrong _sum line.")
+	assert.Contains(t, out, `latency_count{service="users"} 3`, "Missing or wrong _count line.")
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package tallypush pushes metrics into a Tally scope. It's a sibling of
+// prompush and statsdpush: all three implement push.Target, so they can be
+// passed to Controller.Push interchangeably.
+package tallypush
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+
+	"go.uber.org/net/metrics/push"
+)
+
+// target adapts a tally.Scope into a push.Target. Counters and histogram
+// buckets are cumulative in every push.Snapshot, but Tally's Counter.Inc
+// and Histogram.RecordValue are additive - so target remembers what it
+// last reported for each metric and only pushes the delta, rather than
+// re-reporting the running total on every tick.
+type target struct {
+	scope tally.Scope
+
+	mu         sync.Mutex
+	counters   map[string]int64
+	histograms map[string]map[float64]int64
+}
+
+// New adapts scope into a push.Target suitable for Controller.Push.
+func New(scope tally.Scope) push.Target {
+	return &target{
+		scope:      scope,
+		counters:   make(map[string]int64),
+		histograms: make(map[string]map[float64]int64),
+	}
+}
+
+// Push implements push.Target.
+func (t *target) Push(snap push.Snapshot) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range snap.Counters {
+		t.pushCounter(c)
+	}
+	for _, g := range snap.Gauges {
+		t.scope.Tagged(g.Labels).Gauge(g.Name).Update(g.Value)
+	}
+	for _, h := range snap.Histograms {
+		t.pushHistogram(h)
+	}
+	for _, s := range snap.Summaries {
+		t.pushSummary(s)
+	}
+	return nil
+}
+
+func (t *target) pushCounter(c push.Counter) {
+	key := metricKey(c.Name, c.Labels)
+	delta := c.Value - t.counters[key]
+	if delta > 0 {
+		t.scope.Tagged(c.Labels).Counter(c.Name).Inc(delta)
+	}
+	t.counters[key] = c.Value
+}
+
+// pushHistogram reports the delta, since the last push, in how many
+// observations fell into each bucket. Only the bucket counts are known
+// (not the underlying values), so each new observation in a bucket is
+// approximated by recording that bucket's upper bound.
+func (t *target) pushHistogram(h push.Histogram) {
+	key := metricKey(h.Name, h.Labels)
+	last := t.histograms[key]
+
+	bounds := sortedBounds(h.Buckets)
+	buckets := make(tally.ValueBuckets, 0, len(bounds))
+	for _, b := range bounds {
+		if !isOverflowBound(b) {
+			buckets = append(buckets, b)
+		}
+	}
+	histogram := t.scope.Tagged(h.Labels).Histogram(h.Name, buckets)
+
+	var floor int64
+	var lastFloor int64
+	for _, b := range bounds {
+		own := h.Buckets[b] - floor
+		floor = h.Buckets[b]
+
+		lastOwn := last[b] - lastFloor
+		lastFloor = last[b]
+
+		if delta := own - lastOwn; delta > 0 {
+			for i := int64(0); i < delta; i++ {
+				histogram.RecordValue(b)
+			}
+		}
+	}
+
+	t.histograms[key] = h.Buckets
+}
+
+// pushSummary approximates a Summary as a Tally timer. Tally has no
+// quantile-based metric type, and the Summary's Sum/Count describe a
+// rolling MaxAge window rather than a running total, so they can't be
+// delta-tracked the way Counters are - instead, each push records one
+// timer observation equal to the mean of the values in the window.
+func (t *target) pushSummary(s push.Summary) {
+	if s.Count == 0 {
+		return
+	}
+	mean := s.Sum / float64(s.Count)
+	t.scope.Tagged(s.Labels).Timer(s.Name).Record(time.Duration(mean))
+}
+
+// isOverflowBound reports whether b is the sentinel used for the
+// "+Inf" bucket - Tally's ValueBuckets already append an implicit
+// top-ended bucket, so the sentinel itself isn't a configured boundary.
+func isOverflowBound(b float64) bool {
+	return b == math.MaxFloat64
+}
+
+func sortedBounds(buckets map[float64]int64) []float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for b := range buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+func metricKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		} else {
+			b.WriteByte('+')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
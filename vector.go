@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// vectorValues extracts the ordered label values from keyvals (alternating
+// label names and values), panicking if keyvals doesn't have exactly one
+// name/value pair per entry in variableLabels, in the same order.
+func vectorValues(variableLabels []string, keyvals []string) []string {
+	if len(keyvals) != 2*len(variableLabels) {
+		panic(fmt.Sprintf(
+			"metrics: expected %d keyvals (one name/value pair per variable label %v), got %d",
+			2*len(variableLabels), variableLabels, len(keyvals),
+		))
+	}
+	values := make([]string, len(variableLabels))
+	for i, name := range variableLabels {
+		if keyvals[2*i] != name {
+			panic(fmt.Sprintf(
+				"metrics: keyvals out of order: expected label %q at position %d, got %q",
+				name, i, keyvals[2*i],
+			))
+		}
+		values[i] = keyvals[2*i+1]
+	}
+	return values
+}
+
+func vectorChildKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVector is a collection of Counters that share a name and help
+// text but are partitioned by the values of their variable labels.
+type CounterVector struct {
+	variableLabels []string
+	store          *vectorStore
+}
+
+// MustGet retrieves (creating if necessary) the Counter for the supplied
+// variable label values, given as alternating label names and values in
+// the same order as the vector's VariableLabels. It panics if the keyvals
+// don't match the vector's VariableLabels, or if creating a new child
+// would exceed the vector's MaxCardinality.
+func (v *CounterVector) MustGet(keyvals ...string) *Counter {
+	values := vectorValues(v.variableLabels, keyvals)
+	child, err := v.store.getOrCreate(values, func() interface{} { return &Counter{} })
+	if err != nil {
+		panic(fmt.Sprintf("metrics: %v", err))
+	}
+	return child.(*Counter)
+}
+
+// each calls fn once per live child, passing the variable label values (in
+// VariableLabels order) that produced it.
+func (v *CounterVector) each(fn func(values []string, c *Counter)) {
+	v.store.eachEntry(func(values []string, value interface{}) {
+		fn(values, value.(*Counter))
+	})
+}
+
+// GaugeVector is a collection of Gauges that share a name and help text
+// but are partitioned by the values of their variable labels.
+type GaugeVector struct {
+	variableLabels []string
+	store          *vectorStore
+}
+
+// MustGet retrieves (creating if necessary) the Gauge for the supplied
+// variable label values. See CounterVector.MustGet for the keyvals
+// contract and panic conditions.
+func (v *GaugeVector) MustGet(keyvals ...string) *Gauge {
+	values := vectorValues(v.variableLabels, keyvals)
+	child, err := v.store.getOrCreate(values, func() interface{} { return &Gauge{} })
+	if err != nil {
+		panic(fmt.Sprintf("metrics: %v", err))
+	}
+	return child.(*Gauge)
+}
+
+// each calls fn once per live child, passing the variable label values (in
+// VariableLabels order) that produced it.
+func (v *GaugeVector) each(fn func(values []string, g *Gauge)) {
+	v.store.eachEntry(func(values []string, value interface{}) {
+		fn(values, value.(*Gauge))
+	})
+}
+
+// HistogramVector is a collection of Histograms that share a name, help
+// text, and bucket configuration, but are partitioned by the values of
+// their variable labels.
+type HistogramVector struct {
+	variableLabels []string
+	opts           HistogramOpts
+
+	mu       sync.RWMutex
+	children map[string]*Histogram
+}
+
+// MustGet retrieves (creating if necessary) the Histogram for the
+// supplied variable label values. See CounterVector.MustGet for the
+// keyvals contract; a HistogramVector has no cardinality limit, so it
+// panics only for malformed keyvals.
+func (v *HistogramVector) MustGet(keyvals ...string) *Histogram {
+	key := vectorChildKey(vectorValues(v.variableLabels, keyvals))
+
+	v.mu.RLock()
+	h, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if h, ok := v.children[key]; ok {
+		return h
+	}
+	h = newHistogram(v.opts)
+	v.children[key] = h
+	return h
+}
+
+// each calls fn once per live child, passing the variable label values (in
+// VariableLabels order) that produced it.
+func (v *HistogramVector) each(fn func(values []string, h *Histogram)) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for key, h := range v.children {
+		fn(strings.Split(key, "\xff"), h)
+	}
+}
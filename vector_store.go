@@ -0,0 +1,318 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCardinalityLimitExceeded is returned by vectorStore.getOrCreate when
+// creating a new child would exceed the vector's Opts.MaxCardinality.
+// CounterVector.MustGet and GaugeVector.MustGet don't return it; they panic
+// with a message that includes its text.
+var ErrCardinalityLimitExceeded = errors.New("metrics: cardinality limit exceeded")
+
+// _cardinalityLimitCounterName is the name of the meta-counter incremented
+// every time a vector rejects a new child for exceeding its cardinality
+// limit.
+const _cardinalityLimitCounterName = "metrics_cardinality_limit_exceeded_total"
+
+// _shardCount is the number of stripes in a vectorStore's lock-striped map.
+// It's a power of two so that hash-to-shard is a cheap mask rather than a
+// modulo.
+const _shardCount = 32
+
+// vectorStore is the sparse, TTL-evicting, lock-striped storage behind
+// CounterVector and GaugeVector. It replaces the single map guarded by one
+// mutex that MustGet previously serialized all writers on - under
+// concurrent load with many distinct label tuples (e.g. request paths or
+// user IDs), the single lock turned every MustGet into a point of
+// contention regardless of how many distinct children existed.
+//
+// Children are keyed by a 64-bit FNV-1a hash of their label tuple. Hash
+// collisions fall back to comparing the actual tuple, so two different
+// label sets that happen to collide still get distinct children - they
+// just share a bucket.
+type vectorStore struct {
+	shards [_shardCount]shard
+
+	maxCardinality int32 // 0 means unbounded
+	idleTTL        time.Duration
+
+	size               int32 // approximate; used only against maxCardinality
+	limitExceededCount int64
+
+	stopSweep chan struct{}
+}
+
+type shard struct {
+	mu   sync.Mutex
+	byID map[uint64][]*vectorEntry // collision chains
+}
+
+type vectorEntry struct {
+	keyvals    []string
+	value      interface{} // *Counter or *Gauge
+	lastAccess int64       // unix nanos, updated atomically
+}
+
+// newVectorStore constructs a vectorStore honoring the supplied cardinality
+// cap and idle TTL (either may be zero to disable that limit). If idleTTL
+// is set, a background goroutine periodically sweeps expired children; a
+// store built by newVectorStoreFromOpts also runs a goroutine reporting
+// into the shared cardinality meta-counter, whether or not it has a
+// cardinality limit.
+// Neither CounterVector nor GaugeVector nor Registry exposes a way to tear
+// a vector down, so in production these goroutines live for as long as
+// the process does - the same as every other metric this package
+// registers. close exists so this package's own tests can stop them
+// without leaking goroutines across test cases.
+func newVectorStore(maxCardinality int, idleTTL time.Duration) *vectorStore {
+	s := &vectorStore{
+		maxCardinality: int32(maxCardinality),
+		idleTTL:        idleTTL,
+		stopSweep:      make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i].byID = make(map[uint64][]*vectorEntry)
+	}
+	if idleTTL > 0 {
+		go s.sweepLoop()
+	}
+	return s
+}
+
+// close stops every background goroutine owned by the store (the idle
+// sweeper and, if registered, the cardinality meta-counter reporter). It
+// is unused in production - see newVectorStore - and exists only so tests
+// can tear a store down between cases.
+func (s *vectorStore) close() {
+	close(s.stopSweep)
+}
+
+// getOrCreate returns the existing child for keyvals, or creates one with
+// newValue if none exists yet. It returns ErrCardinalityLimitExceeded
+// (without creating anything) if the vector is already at its
+// MaxCardinality; callers that expose a panicking MustGet should translate
+// this error into a panic themselves, matching the vector's existing
+// contract for malformed label lists.
+func (s *vectorStore) getOrCreate(keyvals []string, newValue func() interface{}) (interface{}, error) {
+	id := hashKeyvals(keyvals)
+	sh := &s.shards[id&(_shardCount-1)]
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	chain := sh.byID[id]
+	for _, e := range chain {
+		if keyvalsEqual(e.keyvals, keyvals) {
+			atomic.StoreInt64(&e.lastAccess, time.Now().UnixNano())
+			return e.value, nil
+		}
+	}
+
+	if s.maxCardinality > 0 && atomic.LoadInt32(&s.size) >= s.maxCardinality {
+		atomic.AddInt64(&s.limitExceededCount, 1)
+		return nil, ErrCardinalityLimitExceeded
+	}
+
+	e := &vectorEntry{
+		keyvals:    append([]string(nil), keyvals...),
+		value:      newValue(),
+		lastAccess: time.Now().UnixNano(),
+	}
+	sh.byID[id] = append(chain, e)
+	atomic.AddInt32(&s.size, 1)
+	return e.value, nil
+}
+
+// each calls fn once per live child, in no particular order, without the
+// child's label values. The exporters and push backends enumerate
+// children through eachEntry instead.
+func (s *vectorStore) each(fn func(value interface{})) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, chain := range sh.byID {
+			for _, e := range chain {
+				fn(e.value)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// eachEntry calls fn once per live child, passing the variable label
+// values that produced it alongside the child itself. It's the
+// keyvals-aware counterpart to each, used by CounterVector and
+// GaugeVector's own each methods.
+func (s *vectorStore) eachEntry(fn func(keyvals []string, value interface{})) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, chain := range sh.byID {
+			for _, e := range chain {
+				fn(e.keyvals, e.value)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (s *vectorStore) sweepLoop() {
+	// Check roughly 10 times per TTL window, so an idle child is evicted
+	// within about 10% of its configured TTL.
+	interval := s.idleTTL / 10
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopSweep:
+			return
+		case <-ticker.C:
+			s.evictExpired()
+		}
+	}
+}
+
+func (s *vectorStore) evictExpired() {
+	cutoff := time.Now().Add(-s.idleTTL).UnixNano()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for id, chain := range sh.byID {
+			kept := chain[:0]
+			for _, e := range chain {
+				if atomic.LoadInt64(&e.lastAccess) < cutoff {
+					atomic.AddInt32(&s.size, -1)
+					continue
+				}
+				kept = append(kept, e)
+			}
+			if len(kept) == 0 {
+				delete(sh.byID, id)
+			} else {
+				sh.byID[id] = kept
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func hashKeyvals(keyvals []string) uint64 {
+	h := fnv.New64a()
+	for _, s := range keyvals {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0}) // separator, so {"a", "bc"} != {"ab", "c"}
+	}
+	return h.Sum64()
+}
+
+func keyvalsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedCardinalityCounter returns the metrics_cardinality_limit_exceeded_total
+// Counter scoped to opts.Labels, registering it if this is the first vector
+// to use that label scope, or looking up and returning the counter an
+// earlier vector already registered otherwise. The bool result reports
+// whether a counter was found at all; it's false if this scope's
+// meta-counter collides with an unrelated family of the same name (e.g. a
+// user-defined metric happens to share this package's reserved name with
+// different dimensions) - a condition a vector's own construction
+// shouldn't fail over, so the caller just forgoes reporting.
+//
+// Every vector sharing a label scope must report into the same Counter
+// instance; silently dropping the metric on the "already registered"
+// collision (as a naive NewCounter call would) would lose every rejection
+// but the first vector's.
+func sharedCardinalityCounter(r *Registry, opts Opts) (*Counter, bool) {
+	labels, _, fam, err := r.register(Opts{
+		Name:   _cardinalityLimitCounterName,
+		Help:   "Number of times a vector rejected a new child for exceeding its cardinality limit.",
+		Labels: opts.Labels,
+	}, false /* isVector */, kindCounter)
+	if err == nil {
+		c := &Counter{}
+		r.attachScalar(fam, labels, false, c)
+		return c, true
+	}
+	if fam == nil {
+		return nil, false
+	}
+
+	key := labelValueKey(labels)
+	for _, si := range fam.scalars {
+		if labelValueKey(si.labels) == key {
+			c, ok := si.metric.(*Counter)
+			return c, ok
+		}
+	}
+	return nil, false
+}
+
+// newVectorStoreFromOpts builds a vectorStore honoring a vector's
+// Opts.MaxCardinality and Opts.IdleTTL, and registers (or reuses) the
+// metrics_cardinality_limit_exceeded_total meta-counter scoped to the
+// same registry and constant labels as the vector itself, so operators
+// can alert on label explosion without instrumenting it by hand.
+func newVectorStoreFromOpts(r *Registry, opts Opts) (*vectorStore, error) {
+	s := newVectorStore(opts.MaxCardinality, opts.IdleTTL)
+
+	limitCounter, ok := sharedCardinalityCounter(r, opts)
+	if !ok {
+		return s, nil
+	}
+	go func() {
+		var last int64
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopSweep:
+				return
+			case <-ticker.C:
+				cur := atomic.LoadInt64(&s.limitExceededCount)
+				if delta := cur - last; delta > 0 {
+					limitCounter.Add(delta)
+				}
+				last = cur
+			}
+		}
+	}()
+	return s, nil
+}
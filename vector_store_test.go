@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVectorStoreGetOrCreate(t *testing.T) {
+	s := newVectorStore(0, 0)
+	defer s.close()
+
+	v1, err := s.getOrCreate([]string{"foo", "bar"}, func() interface{} { return 1 })
+	require.NoError(t, err)
+	v2, err := s.getOrCreate([]string{"foo", "bar"}, func() interface{} { return 2 })
+	require.NoError(t, err)
+	assert.Equal(t, v1, v2, "Should return the existing child rather than creating a new one.")
+
+	v3, err := s.getOrCreate([]string{"foo", "baz"}, func() interface{} { return 3 })
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, v3, "Distinct label tuples should get distinct children.")
+}
+
+func TestVectorStoreCardinalityLimit(t *testing.T) {
+	s := newVectorStore(2, 0)
+	defer s.close()
+
+	_, err := s.getOrCreate([]string{"a"}, func() interface{} { return struct{}{} })
+	require.NoError(t, err)
+	_, err = s.getOrCreate([]string{"b"}, func() interface{} { return struct{}{} })
+	require.NoError(t, err)
+
+	_, err = s.getOrCreate([]string{"c"}, func() interface{} { return struct{}{} })
+	assert.ErrorIs(t, err, ErrCardinalityLimitExceeded)
+
+	// Existing children remain accessible past the limit.
+	_, err = s.getOrCreate([]string{"a"}, func() interface{} { return struct{}{} })
+	assert.NoError(t, err)
+}
+
+func TestVectorStoreIdleEviction(t *testing.T) {
+	s := newVectorStore(0, 30*time.Millisecond)
+	defer s.close()
+
+	_, err := s.getOrCreate([]string{"idle"}, func() interface{} { return struct{}{} })
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		count := 0
+		s.each(func(interface{}) { count++ })
+		return count == 0
+	}, time.Second, 5*time.Millisecond, "Idle child should eventually be evicted.")
+}
+
+func TestSharedCardinalityCounterIsSharedAcrossVectors(t *testing.T) {
+	r, _ := New()
+	opts := Opts{Labels: Labels{"shard": "east"}}
+
+	first, ok := sharedCardinalityCounter(r, opts)
+	require.True(t, ok)
+	second, ok := sharedCardinalityCounter(r, opts)
+	require.True(t, ok)
+
+	first.Add(5)
+	second.Add(5)
+	assert.Equal(t, int64(10), first.Load(), "Two vectors sharing a label scope should report into the same counter.")
+	assert.Same(t, first, second, "Should return the existing counter rather than losing track of it.")
+}
+
+func TestSharedCardinalityCounterDistinctLabelsGetDistinctCounters(t *testing.T) {
+	r, _ := New()
+
+	east, ok := sharedCardinalityCounter(r, Opts{Labels: Labels{"shard": "east"}})
+	require.True(t, ok)
+	west, ok := sharedCardinalityCounter(r, Opts{Labels: Labels{"shard": "west"}})
+	require.True(t, ok)
+
+	east.Add(3)
+	assert.Equal(t, int64(0), west.Load(), "Different label scopes must not share a counter.")
+}
+
+func TestNewVectorStoreFromOptsReportsIntoSharedCounter(t *testing.T) {
+	r, _ := New()
+	opts := Opts{Labels: Labels{"shard": "east"}, MaxCardinality: 1}
+
+	counter, ok := sharedCardinalityCounter(r, opts)
+	require.True(t, ok)
+
+	rejections := func(s *vectorStore) {
+		_, err := s.getOrCreate([]string{"a"}, func() interface{} { return struct{}{} })
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			_, err := s.getOrCreate([]string{fmt.Sprintf("extra-%d", i)}, func() interface{} { return struct{}{} })
+			assert.ErrorIs(t, err, ErrCardinalityLimitExceeded)
+		}
+	}
+
+	s1, err := newVectorStoreFromOpts(r, opts)
+	require.NoError(t, err)
+	defer s1.close()
+	s2, err := newVectorStoreFromOpts(r, opts)
+	require.NoError(t, err)
+	defer s2.close()
+
+	rejections(s1)
+	rejections(s2)
+
+	require.Eventually(t, func() bool {
+		return counter.Load() == 10
+	}, 3*time.Second, 5*time.Millisecond, "Both vectors' rejections should accumulate in the one shared counter, not 5 each lost.")
+}
+
+func TestVectorStoreConcurrentAccessCreatesOneChildPerKey(t *testing.T) {
+	s := newVectorStore(0, 0)
+	defer s.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.getOrCreate([]string{fmt.Sprintf("key-%d", i)}, func() interface{} { return i })
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	s.each(func(interface{}) { count++ })
+	assert.Equal(t, 100, count, "Expected one child per distinct key.")
+}